@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Metric is a single value/unit pair of a benchmark result line,
+// e.g. 1234 ns/op, 64 B/op or any unit reported via b.ReportMetric.
+type Metric struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// BenchmarkResult holds one result line of go test -bench output.
+type BenchmarkResult struct {
+	// Name is the full benchmark name, without the GOMAXPROCS suffix.
+	Name string `json:"name"`
+	// Procs is the GOMAXPROCS value the benchmark ran with.
+	Procs int `json:"procs"`
+	// Iterations is the number of times the benchmark loop ran (b.N).
+	Iterations int64 `json:"iterations"`
+	// Metrics holds the reported metrics in order of appearance.
+	Metrics []Metric `json:"metrics"`
+}
+
+// Metric returns the value reported with the given unit, if any.
+func (r BenchmarkResult) Metric(unit string) (float64, bool) {
+	for _, m := range r.Metrics {
+		if m.Unit == unit {
+			return m.Value, true
+		}
+	}
+	return 0, false
+}
+
+// String formats the result the way go test does.
+func (r BenchmarkResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\t%d", r.Name, r.Iterations)
+	for _, m := range r.Metrics {
+		fmt.Fprintf(&b, "\t%s %s", strconv.FormatFloat(m.Value, 'f', -1, 64), m.Unit)
+	}
+	return b.String()
+}
+
+// parseBenchmarkLine parses a go test benchmark result line, e.g.
+//
+//	BenchmarkGet-8   	 1000000	      1043 ns/op	  64 B/op	       2 allocs/op
+//
+// name is returned as printed, including the GOMAXPROCS suffix if any.
+func parseBenchmarkLine(line string) (name string, iterations int64, metrics []Metric, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || len(fields)%2 != 0 || !strings.HasPrefix(fields[0], "Benchmark") {
+		return
+	}
+	iterations, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return
+	}
+	for i := 2; i < len(fields); i += 2 {
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return "", 0, nil, false
+		}
+		metrics = append(metrics, Metric{Value: value, Unit: fields[i+1]})
+	}
+	return fields[0], iterations, metrics, true
+}
+
+// benchmarkResults returns the results the given benchmark reported in the
+// go test output, one per run, along with those of its sub-benchmarks when
+// it was run as a whole.
+func benchmarkResults(output []byte, b Benchmark) (results []BenchmarkResult) {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		name, iterations, metrics, ok := parseBenchmarkLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		procs := 1
+		if name != b.FullName() {
+			if suffix := procsSuffixRegExp.FindString(name); suffix != "" && ranAs(strings.TrimSuffix(name, suffix), b.FullName()) {
+				name = strings.TrimSuffix(name, suffix)
+				procs, _ = strconv.Atoi(suffix[1:])
+			} else if !ranAs(name, b.FullName()) {
+				continue
+			}
+		}
+		results = append(results, BenchmarkResult{Name: name, Procs: procs, Iterations: iterations, Metrics: metrics})
+	}
+	return
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_benchmarkResults(t *testing.T) {
+	output := `goos: linux
+goarch: amd64
+pkg: example.com/mono/store
+cpu: Intel(R) Xeon(R) CPU @ 2.20GHz
+BenchmarkGet-8         	 1000000	      1043 ns/op	  98.13 MB/s	      64 B/op	       2 allocs/op
+BenchmarkGet-8         	 1200000	       998.5 ns/op	 102.5 MB/s	      64 B/op	       2 allocs/op
+BenchmarkGetAll-8      	   10000	    104300 ns/op
+BenchmarkGet/hit-4     	     100	        10.00 ns/op	     0.9500 hit-ratio
+PASS
+ok  	example.com/mono/store	3.210s
+`
+	tests := []struct {
+		name string
+		b    Benchmark
+		want []BenchmarkResult
+	}{
+		{"runs", Benchmark{Name: "BenchmarkGet"}, []BenchmarkResult{
+			{Name: "BenchmarkGet", Procs: 8, Iterations: 1000000, Metrics: []Metric{{1043, "ns/op"}, {98.13, "MB/s"}, {64, "B/op"}, {2, "allocs/op"}}},
+			{Name: "BenchmarkGet", Procs: 8, Iterations: 1200000, Metrics: []Metric{{998.5, "ns/op"}, {102.5, "MB/s"}, {64, "B/op"}, {2, "allocs/op"}}},
+			{Name: "BenchmarkGet/hit", Procs: 4, Iterations: 100, Metrics: []Metric{{10, "ns/op"}, {0.95, "hit-ratio"}}},
+		}},
+		{"custom-metric", Benchmark{Name: "BenchmarkGet", Sub: "hit"}, []BenchmarkResult{
+			{Name: "BenchmarkGet/hit", Procs: 4, Iterations: 100, Metrics: []Metric{{10, "ns/op"}, {0.95, "hit-ratio"}}},
+		}},
+		{"missing", Benchmark{Name: "BenchmarkPut"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := benchmarkResults([]byte(output), tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("benchmarkResults() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseBenchmarkLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		wantOk bool
+	}{
+		{"result", "BenchmarkGet-8   \t 1000\t 1234 ns/op", true},
+		{"header", "goos: linux", false},
+		{"name-only", "BenchmarkGet-8   \t", false},
+		{"odd-fields", "BenchmarkGet-8   \t 1000\t 1234", false},
+		{"not-a-number", "BenchmarkGet-8   \t 1000\t fast ns/op", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, ok := parseBenchmarkLine(tt.line); ok != tt.wantOk {
+				t.Errorf("parseBenchmarkLine() ok = %v, want %v", ok, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// changedFiles returns the slash separated paths, relative to the repository
+// root, of the files changed between the merge base of HEAD and the given
+// revision, and HEAD.
+func changedFiles(r *git.Repository, since string) ([]string, error) {
+	baseHash, err := r.ResolveRevision(plumbing.Revision(since))
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve %s: %v", since, err)
+	}
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	headCommit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	baseCommit, err := r.CommitObject(*baseHash)
+	if err != nil {
+		return nil, err
+	}
+	if bases, err := headCommit.MergeBase(baseCommit); err == nil && len(bases) > 0 {
+		baseCommit = bases[0]
+	}
+	log.Printf("Comparing HEAD %s against %s (%s).", getShortHash(headCommit.Hash.String(), 7), since, getShortHash(baseCommit.Hash.String(), 7))
+	baseTree, err := baseCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := headCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := baseTree.Diff(headTree)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var files []string
+	for _, change := range changes {
+		for _, name := range []string{change.From.Name, change.To.Name} {
+			if name != "" && !seen[name] {
+				seen[name] = true
+				files = append(files, name)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// listedPackage holds the go list -json fields used to build the import graph.
+type listedPackage struct {
+	ImportPath   string
+	Dir          string
+	Module       *struct{ Dir string }
+	Imports      []string
+	TestImports  []string
+	XTestImports []string
+}
+
+// listPackages runs go list on every package of the module rooted at dir,
+// with the environment and build flags the test binaries are built with.
+func listPackages(goPath string, dir string, tags []string) ([]listedPackage, error) {
+	args := []string{"list", "-e", "-json"}
+	if len(tags) > 0 {
+		args = append(args, "-tags="+strings.Join(tags, ","))
+	}
+	args = append(args, passThrough.Build...)
+	args = append(args, "./...")
+	c := exec.Command(goPath, args...)
+	c.Dir = dir
+	c.Env = commandEnv()
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list failed in %s: %v", dir, err)
+	}
+	var pkgs []listedPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var pkg listedPackage
+		if err := dec.Decode(&pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// changedPackageDir returns the directory of the package a changed file
+// belongs to. Files under testdata are attributed to the package owning the
+// testdata directory.
+func changedPackageDir(file string) string {
+	dir := path.Dir(file)
+	parts := strings.Split(dir, "/")
+	for i, part := range parts {
+		if part == "testdata" {
+			return path.Join(append([]string{"."}, parts[:i]...)...)
+		}
+	}
+	return dir
+}
+
+// affectedPackages returns the directories of the packages affected by the
+// changed files, mapped to the reason why. A package is affected when one of
+// its files changed, when the go.mod or go.sum of its module changed, or when
+// it (or its tests) imports an affected package. root is the absolute
+// directory the changed file paths are relative to.
+func affectedPackages(root string, pkgs []listedPackage, changed []string) map[string]string {
+	byDir := map[string]listedPackage{}
+	importers := map[string][]listedPackage{}
+	for _, pkg := range pkgs {
+		byDir[pkg.Dir] = pkg
+		for _, imports := range [][]string{pkg.Imports, pkg.TestImports, pkg.XTestImports} {
+			for _, imp := range imports {
+				if imp != pkg.ImportPath {
+					importers[imp] = append(importers[imp], pkg)
+				}
+			}
+		}
+	}
+	affected := map[string]string{}
+	var queue []listedPackage
+	mark := func(pkg listedPackage, reason string) {
+		if _, ok := affected[pkg.Dir]; ok {
+			return
+		}
+		affected[pkg.Dir] = reason
+		queue = append(queue, pkg)
+	}
+	for _, file := range changed {
+		abs := filepath.Join(root, filepath.FromSlash(file))
+		if base := path.Base(file); base == "go.mod" || base == "go.sum" {
+			for _, pkg := range pkgs {
+				if pkg.Module != nil && pkg.Module.Dir == filepath.Dir(abs) {
+					mark(pkg, fmt.Sprintf("%s changed", file))
+				}
+			}
+			continue
+		}
+		if pkg, ok := byDir[filepath.Join(root, filepath.FromSlash(changedPackageDir(file)))]; ok {
+			mark(pkg, fmt.Sprintf("%s changed", file))
+		}
+	}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		for _, importer := range importers[pkg.ImportPath] {
+			mark(importer, fmt.Sprintf("imports %s", pkg.ImportPath))
+		}
+	}
+	return affected
+}
+
+// selectChangedBenchmarks keeps the benchmarks of the packages affected by the
+// changes since the given revision, logging which were selected and why.
+func selectChangedBenchmarks(r *git.Repository, goPath string, discovery Discovery, benchmarks []Benchmark, since string) ([]Benchmark, error) {
+	if r == nil {
+		return nil, fmt.Errorf("--since requires the current directory to be the root of a git repository")
+	}
+	files, err := changedFiles(r, since)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Detected %d changed files since %s.", len(files), since)
+	// go list reports directories with symlinks evaluated.
+	root, err := filepath.Abs(".")
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return nil, err
+	}
+	moduleDirs := []string{"."}
+	if len(discovery.Modules) > 0 {
+		moduleDirs = nil
+		for _, module := range discovery.Modules {
+			if module.Excluded == "" {
+				moduleDirs = append(moduleDirs, module.Dir)
+			}
+		}
+	}
+	var pkgs []listedPackage
+	for _, dir := range moduleDirs {
+		modulePkgs, err := listPackages(goPath, filepath.FromSlash(dir), tags)
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, modulePkgs...)
+	}
+	affected := affectedPackages(root, pkgs, files)
+	var selected []Benchmark
+	skipped := 0
+	for _, benchmark := range benchmarks {
+		reason, ok := affected[filepath.Join(root, filepath.FromSlash(benchmark.Dir))]
+		if !ok {
+			skipped++
+			continue
+		}
+		log.Printf("Selected %s (%s): %s.", benchmark.FullName(), benchmark.ImportPath, reason)
+		selected = append(selected, benchmark)
+	}
+	log.Printf("Skipping %d benchmarks of packages unaffected by the changes since %s.", skipped, since)
+	return selected, nil
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_changedPackageDir(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"main.go", "."},
+		{"pkg/store/store.go", "pkg/store"},
+		{"pkg/store/testdata/golden.json", "pkg/store"},
+		{"testdata/nested/input.txt", "."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			if got := changedPackageDir(tt.file); got != tt.want {
+				t.Errorf("changedPackageDir() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_affectedPackages(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+	module := &struct{ Dir string }{root}
+	pkg := func(dir string, importPath string, imports []string, testImports []string) listedPackage {
+		return listedPackage{ImportPath: importPath, Dir: filepath.Join(root, filepath.FromSlash(dir)), Module: module, Imports: imports, TestImports: testImports}
+	}
+	pkgs := []listedPackage{
+		pkg("codec", "example.com/m/codec", nil, nil),
+		pkg("store", "example.com/m/store", []string{"example.com/m/codec"}, nil),
+		pkg("api", "example.com/m/api", []string{"example.com/m/store"}, nil),
+		pkg("bench", "example.com/m/bench", nil, []string{"example.com/m/api"}),
+		pkg("other", "example.com/m/other", nil, nil),
+	}
+	abs := func(dir string) string { return filepath.Join(root, dir) }
+	tests := []struct {
+		name    string
+		changed []string
+		want    map[string]string
+	}{
+		{"none", []string{"README.md"}, map[string]string{}},
+		{"leaf", []string{"api/api.go"}, map[string]string{
+			abs("api"):   "api/api.go changed",
+			abs("bench"): "imports example.com/m/api",
+		}},
+		{"transitive", []string{"codec/testdata/golden.bin"}, map[string]string{
+			abs("codec"): "codec/testdata/golden.bin changed",
+			abs("store"): "imports example.com/m/codec",
+			abs("api"):   "imports example.com/m/store",
+			abs("bench"): "imports example.com/m/api",
+		}},
+		{"go.mod", []string{"go.mod"}, map[string]string{
+			abs("codec"): "go.mod changed",
+			abs("store"): "go.mod changed",
+			abs("api"):   "go.mod changed",
+			abs("bench"): "go.mod changed",
+			abs("other"): "go.mod changed",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := affectedPackages(root, pkgs, tt.changed); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("affectedPackages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// coverageRun is a go test run measuring the coverage of a package while
+// running some of its benchmarks.
+type coverageRun struct {
+	// Package is the package pattern relative to the module root.
+	Package string
+	// Bench is the -bench expression selecting the benchmarks.
+	Bench string
+}
+
+// coverageTarget holds the coverage runs of the packages of a module, run
+// from the module root.
+type coverageTarget struct {
+	Module    string
+	ModuleDir string
+	Runs      []coverageRun
+}
+
+// coverageTargets returns the runs measuring the coverage of the benchmarks
+// of the given outcomes, grouped by module in order of first appearance.
+// Only the benchmarks which passed, or were cached by --resume, with every
+// --cpu value are run again, so that neither failing benchmarks nor those
+// excluded by --bench, --skip-bench, --since or the sub-benchmark selection
+// fail the coverage. As a -bench expression selects sub-benchmarks level by
+// level for every benchmark it names, benchmarks of a package share a run
+// only when the same sub-benchmarks of theirs were selected.
+func coverageTargets(outcomes []benchmarkOutcome) (targets []coverageTarget) {
+	key := func(b Benchmark) string { return b.ImportPath + " " + b.FullName() }
+	failed := map[string]bool{}
+	for _, o := range outcomes {
+		if o.Status != statusPassed && o.Status != statusCached {
+			failed[key(o.Benchmark)] = true
+		}
+	}
+	// selection holds the selected benchmarks of a package, by name: nil
+	// when run as a whole, else their selected sub-benchmarks.
+	type selection struct {
+		b     Benchmark
+		names []string
+		subs  map[string][]string
+	}
+	var pkgs []*selection
+	byPackage := map[string]*selection{}
+	for _, o := range outcomes {
+		b := o.Benchmark
+		if failed[key(b)] {
+			continue
+		}
+		p, ok := byPackage[b.ImportPath]
+		if !ok {
+			p = &selection{b: b, subs: map[string][]string{}}
+			byPackage[b.ImportPath] = p
+			pkgs = append(pkgs, p)
+		}
+		subs, seen := p.subs[b.Name]
+		if !seen {
+			p.names = append(p.names, b.Name)
+		}
+		if b.Sub == "" || (seen && subs == nil) {
+			p.subs[b.Name] = nil
+		} else if !slices.Contains(subs, b.Sub) {
+			p.subs[b.Name] = append(subs, b.Sub)
+		}
+	}
+	modules := map[string]int{}
+	for _, p := range pkgs {
+		i, ok := modules[p.b.ModuleDir]
+		if !ok {
+			i = len(targets)
+			modules[p.b.ModuleDir] = i
+			targets = append(targets, coverageTarget{Module: p.b.Module, ModuleDir: p.b.ModuleDir})
+		}
+		var groups []string
+		names := map[string][]string{}
+		for _, name := range p.names {
+			subs := append([]string(nil), p.subs[name]...)
+			sort.Strings(subs)
+			group := strings.Join(subs, "\n")
+			if _, ok := names[group]; !ok {
+				groups = append(groups, group)
+			}
+			names[group] = append(names[group], name)
+		}
+		for _, group := range groups {
+			var subs []string
+			if group != "" {
+				subs = strings.Split(group, "\n")
+			}
+			targets[i].Runs = append(targets[i].Runs, coverageRun{Package: p.b.ModulePackage(), Bench: coverageBenchPattern(names[group], subs)})
+		}
+	}
+	return
+}
+
+// coverageBenchPattern returns the -bench expression selecting the given
+// benchmarks, and only the given sub-benchmarks of theirs if any.
+func coverageBenchPattern(names []string, subs []string) string {
+	levels := [][]string{names}
+	for _, sub := range subs {
+		for i, part := range strings.Split(sub, "/") {
+			if len(levels) <= i+1 {
+				levels = append(levels, nil)
+			}
+			if !slices.Contains(levels[i+1], part) {
+				levels[i+1] = append(levels[i+1], part)
+			}
+		}
+	}
+	elems := make([]string, len(levels))
+	for i, parts := range levels {
+		quoted := make([]string, len(parts))
+		for j, part := range parts {
+			quoted[j] = regexp.QuoteMeta(part)
+		}
+		elems[i] = "^(" + strings.Join(quoted, "|") + ")$"
+	}
+	return strings.Join(elems, "/")
+}
+
+// parseCoverProfile returns the number of covered and total statements of the
+// content of a go test -coverprofile file. Blocks listed several times, e.g.
+// by packages tested together, are counted once.
+func parseCoverProfile(content string) (covered int64, total int64, err error) {
+	blocks := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		// name.go:line.column,line.column numberOfStatements count
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return 0, 0, fmt.Errorf("invalid coverage profile line %q", line)
+		}
+		statements, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid coverage profile line %q", line)
+		}
+		count, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid coverage profile line %q", line)
+		}
+		wasCovered, listed := blocks[fields[0]]
+		if !listed {
+			total += statements
+		}
+		if count > 0 && !wasCovered {
+			covered += statements
+		}
+		blocks[fields[0]] = wasCovered || count > 0
+	}
+	return covered, total, scanner.Err()
+}
+
+// benchmarkCoverage measures the statement coverage of the packages of the
+// given benchmark outcomes when running the benchmarks which passed, running
+// go test from the root of every module. It returns the coverage percentage
+// over all modules, empty when no benchmark passed. The coverage profile of
+// every module is written to the run output and recorded in its manifest.
+func benchmarkCoverage(ctx context.Context, goPath string, outcomes []benchmarkOutcome) (string, error) {
+	targets := coverageTargets(outcomes)
+	if len(targets) == 0 {
+		log.Printf("Skipping the benchmark coverage, no benchmark passed.")
+		return "", nil
+	}
+	tmpDir, err := os.MkdirTemp("", "codeperf-coverage")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	var covered, total int64
+	for _, target := range targets {
+		coverprofile := output.path("coverage.out")
+		if target.ModuleDir != "." {
+			coverprofile = output.path("modules", fileNameReplacer.Replace(target.Module), "coverage.out")
+			if err := os.MkdirAll(filepath.Dir(coverprofile), 0755); err != nil {
+				return "", err
+			}
+		}
+		var merged bytes.Buffer
+		for i, run := range target.Runs {
+			profile := filepath.Join(tmpDir, fmt.Sprintf("%d.out", i))
+			c := command{Name: goPath, Args: []string{"test"}, Dir: filepath.FromSlash(target.ModuleDir), Env: commandEnv()}
+			if len(tags) > 0 {
+				c.Args = append(c.Args, "-tags="+strings.Join(tags, ","))
+			}
+			c.Args = append(c.Args, goTestArgs...)
+			c.Args = append(c.Args, "-cover", "-bench="+run.Bench, "-benchtime=0.01s", "-coverprofile", profile, run.Package)
+			log.Println(fmt.Sprintf("Calculating the benchmark coverage of %s with the following command: %s.", target.Module, c))
+			if out, err := c.run(ctx); err != nil {
+				return "", commandError(c, err, out)
+			}
+			content, err := os.ReadFile(profile)
+			if err != nil {
+				return "", err
+			}
+			mergeCoverProfile(&merged, content)
+		}
+		if err := os.WriteFile(coverprofile, merged.Bytes(), 0644); err != nil {
+			return "", err
+		}
+		output.addFile(coverprofile)
+		moduleCovered, moduleTotal, err := parseCoverProfile(merged.String())
+		if err != nil {
+			return "", err
+		}
+		covered += moduleCovered
+		total += moduleTotal
+	}
+	if total == 0 {
+		return "0.0", nil
+	}
+	return fmt.Sprintf("%.1f", 100*float64(covered)/float64(total)), nil
+}
+
+// mergeCoverProfile appends the blocks of the coverage profile content to
+// merged, keeping a single mode line.
+func mergeCoverProfile(merged *bytes.Buffer, content []byte) {
+	mode, blocks, _ := bytes.Cut(content, []byte("\n"))
+	if merged.Len() == 0 {
+		merged.Write(mode)
+		merged.WriteByte('\n')
+	}
+	merged.Write(blocks)
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func Test_coverageTargets(t *testing.T) {
+	a := Benchmark{Dir: "a", ImportPath: "example.com/mono/a", Module: "example.com/mono", ModuleDir: "."}
+	sub := Benchmark{Dir: "sub", ImportPath: "example.com/mono/sub", Module: "example.com/mono", ModuleDir: "."}
+	nested := Benchmark{Dir: "nested/store", ImportPath: "example.com/nested/store", Module: "example.com/nested", ModuleDir: "nested"}
+	outcome := func(b Benchmark, name string, subName string, procs int, status string) benchmarkOutcome {
+		b.Name, b.Sub, b.Procs = name, subName, procs
+		return benchmarkOutcome{Benchmark: b, Status: status}
+	}
+	outcomes := []benchmarkOutcome{
+		outcome(a, "BenchmarkGet", "", 1, statusPassed),
+		outcome(a, "BenchmarkGet", "", 2, statusPassed),
+		outcome(a, "BenchmarkPut", "", 0, statusCached),
+		outcome(a, "BenchmarkTable", "small", 0, statusPassed),
+		outcome(a, "BenchmarkTable", "large_input", 0, statusPassed),
+		outcome(a, "BenchmarkScan", "small", 0, statusPassed),
+		outcome(a, "BenchmarkScan", "large_input", 0, statusPassed),
+		outcome(a, "BenchmarkSort", "small", 0, statusPassed),
+		outcome(a, "BenchmarkFlaky", "", 1, statusPassed),
+		outcome(a, "BenchmarkFlaky", "", 2, statusFailed),
+		outcome(sub, "BenchmarkFail", "", 0, statusFailed),
+		outcome(sub, "BenchmarkSkipped", "", 0, statusSkipped),
+		outcome(nested, "BenchmarkNested", "a/b", 0, statusPassed),
+	}
+	want := []coverageTarget{
+		{Module: "example.com/mono", ModuleDir: ".", Runs: []coverageRun{
+			{Package: "./a", Bench: "^(BenchmarkGet|BenchmarkPut)$"},
+			{Package: "./a", Bench: "^(BenchmarkTable|BenchmarkScan)$/^(large_input|small)$"},
+			{Package: "./a", Bench: "^(BenchmarkSort)$/^(small)$"},
+		}},
+		{Module: "example.com/nested", ModuleDir: "nested", Runs: []coverageRun{
+			{Package: "./store", Bench: "^(BenchmarkNested)$/^(a)$/^(b)$"},
+		}},
+	}
+	if got := coverageTargets(outcomes); !reflect.DeepEqual(got, want) {
+		t.Errorf("coverageTargets() = %+v, want %+v", got, want)
+	}
+	if got := coverageTargets(outcomes[9:12]); len(got) != 0 {
+		t.Errorf("coverageTargets() = %+v, want none when no benchmark passed", got)
+	}
+}
+
+func Test_coverageBenchPattern(t *testing.T) {
+	tests := []struct {
+		names []string
+		subs  []string
+		want  string
+	}{
+		{[]string{"BenchmarkGet"}, nil, "^(BenchmarkGet)$"},
+		{[]string{"BenchmarkGet", "BenchmarkPut"}, []string{"size=10"}, "^(BenchmarkGet|BenchmarkPut)$/^(size=10)$"},
+		{[]string{"BenchmarkGet"}, []string{"a.b/c", "d/c"}, "^(BenchmarkGet)$/^(a\\.b|d)$/^(c)$"},
+	}
+	for _, tt := range tests {
+		if got := coverageBenchPattern(tt.names, tt.subs); got != tt.want {
+			t.Errorf("coverageBenchPattern(%q, %q) = %q, want %q", tt.names, tt.subs, got, tt.want)
+		}
+	}
+}
+
+func Test_parseCoverProfile(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		wantCovered int64
+		wantTotal   int64
+		wantErr     bool
+	}{
+		{"empty", "mode: set\n", 0, 0, false},
+		{"set", "mode: set\nexample.com/a/a.go:3.20,5.2 2 1\nexample.com/a/a.go:7.20,9.2 3 0\n", 2, 5, false},
+		{"duplicate blocks", "mode: set\nexample.com/a/a.go:3.20,5.2 2 0\nexample.com/a/a.go:3.20,5.2 2 1\nexample.com/b/b.go:1.1,2.2 1 1\n", 3, 3, false},
+		{"invalid", "mode: set\nexample.com/a/a.go:3.20,5.2 two 1\n", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			covered, total, err := parseCoverProfile(tt.content)
+			if (err != nil) != tt.wantErr || covered != tt.wantCovered || total != tt.wantTotal {
+				t.Errorf("parseCoverProfile() = %d, %d, %v, want %d, %d, error %v", covered, total, err, tt.wantCovered, tt.wantTotal, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_mergeCoverProfile(t *testing.T) {
+	var merged bytes.Buffer
+	mergeCoverProfile(&merged, []byte("mode: set\nexample.com/a/a.go:3.20,5.2 2 1\n"))
+	mergeCoverProfile(&merged, []byte("mode: set\nexample.com/a/a.go:3.20,5.2 2 0\nexample.com/a/a.go:7.20,9.2 3 0\n"))
+	want := "mode: set\nexample.com/a/a.go:3.20,5.2 2 1\nexample.com/a/a.go:3.20,5.2 2 0\nexample.com/a/a.go:7.20,9.2 3 0\n"
+	if merged.String() != want {
+		t.Errorf("mergeCoverProfile() = %q, want %q", merged.String(), want)
+	}
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const directivePrefix = "//codeperf:"
+
+// Directives holds the per-benchmark settings given by //codeperf: comments
+// in the benchmark doc comment, e.g.
+//
+//	//codeperf:benchtime=30s
+//	//codeperf:count=5
+//	//codeperf:profiles=cpu,mem
+//	//codeperf:skip
+//	func BenchmarkGet(b *testing.B) {
+//
+// Zero values mean the command line settings apply.
+type Directives struct {
+	Benchtime string   `json:"benchtime,omitempty"`
+	Count     int      `json:"count,omitempty"`
+	Profiles  []string `json:"profiles,omitempty"`
+	Skip      bool     `json:"skip,omitempty"`
+}
+
+var benchtimeIterationsRegExp = regexp.MustCompile(`^[1-9][0-9]*x$`)
+
+// parseDirectives extracts the codeperf directives from a doc comment.
+// Malformed or unknown directives are returned as diagnostics, positioned but
+// without file name.
+func parseDirectives(fset *token.FileSet, doc *ast.CommentGroup) (directives Directives, diagnostics []Diagnostic) {
+	if doc == nil {
+		return
+	}
+	for _, comment := range doc.List {
+		if !strings.HasPrefix(comment.Text, directivePrefix) {
+			continue
+		}
+		directive := strings.TrimSpace(strings.TrimPrefix(comment.Text, directivePrefix))
+		if err := directives.set(directive); err != nil {
+			pos := fset.Position(comment.Pos())
+			diagnostics = append(diagnostics, Diagnostic{Line: pos.Line, Column: pos.Column, Message: err.Error()})
+		}
+	}
+	return
+}
+
+func (d *Directives) set(directive string) error {
+	key, value, hasValue := strings.Cut(directive, "=")
+	switch key {
+	case "skip":
+		if hasValue {
+			return fmt.Errorf("directive %s%s does not take a value", directivePrefix, key)
+		}
+		d.Skip = true
+		return nil
+	case "benchtime", "count", "profiles":
+		if !hasValue || value == "" {
+			return fmt.Errorf("directive %s%s requires a value", directivePrefix, key)
+		}
+	default:
+		return fmt.Errorf("unknown directive %s%s", directivePrefix, key)
+	}
+	switch key {
+	case "benchtime":
+		if _, err := time.ParseDuration(value); err != nil && !benchtimeIterationsRegExp.MatchString(value) {
+			return fmt.Errorf("invalid %sbenchtime %q: expected a duration or Nx", directivePrefix, value)
+		}
+		d.Benchtime = value
+	case "count":
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 1 {
+			return fmt.Errorf("invalid %scount %q: expected a positive integer", directivePrefix, value)
+		}
+		d.Count = count
+	case "profiles":
+		kinds, err := parseProfileKinds(strings.Split(value, ","))
+		if err != nil {
+			return fmt.Errorf("invalid %sprofiles: %v", directivePrefix, err)
+		}
+		d.Profiles = nil
+		for _, kind := range kinds {
+			d.Profiles = append(d.Profiles, kind.Name)
+		}
+	}
+	return nil
+}
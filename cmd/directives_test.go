@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+)
+
+func Test_parseDirectives(t *testing.T) {
+	tests := []struct {
+		name      string
+		doc       string
+		want      Directives
+		wantDiags int
+	}{
+		{"none", "// BenchmarkGet measures Get.\n", Directives{}, 0},
+		{"all", "// BenchmarkGet measures Get.\n//codeperf:benchtime=30s\n//codeperf:count=5\n//codeperf:profiles=cpu\n//codeperf:skip\n", Directives{Benchtime: "30s", Count: 5, Profiles: []string{"cpu"}, Skip: true}, 0},
+		{"iterations", "//codeperf:benchtime=1000x\n", Directives{Benchtime: "1000x"}, 0},
+		{"spaced-comment-ignored", "// codeperf:skip\n", Directives{}, 0},
+		{"unknown", "//codeperf:benchmem\n", Directives{}, 1},
+		{"invalid-count", "//codeperf:count=0\n", Directives{}, 1},
+		{"invalid-benchtime", "//codeperf:benchtime=soon\n", Directives{}, 1},
+		{"unknown-profile", "//codeperf:profiles=cpu,gpu\n", Directives{}, 1},
+		{"missing-value", "//codeperf:count\n", Directives{}, 1},
+		{"skip-with-value", "//codeperf:skip=true\n", Directives{}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package p\n\nimport \"testing\"\n\n" + tt.doc + "func BenchmarkGet(b *testing.B) {}\n"
+			fset := token.NewFileSet()
+			file, err := parser.ParseFile(fset, "p_test.go", src, parser.ParseComments)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, diags := parseDirectives(fset, file.Decls[1].(*ast.FuncDecl).Doc)
+			if len(diags) != tt.wantDiags {
+				t.Fatalf("parseDirectives() diagnostics = %v, want %d", diags, tt.wantDiags)
+			}
+			if tt.wantDiags == 0 && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDirectives() = %+v, want %+v", got, tt.want)
+			}
+			for _, diag := range diags {
+				if diag.Line == 0 {
+					t.Errorf("parseDirectives() diagnostic %v has no position", diag)
+				}
+			}
+		})
+	}
+}
@@ -12,7 +12,9 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -40,140 +42,318 @@ func exportLogic() func(cmd *cobra.Command, args []string) {
 		}
 		inputName := args[0]
 		granularityOptions := []string{"lines", "functions"}
-		exportFromPprof(inputName, bench, granularityOptions)
+		if err := exportFromPprof(inputName, "", bench, "", cpuProfileKind.exportPath(""), "", granularityOptions, ""); err != nil {
+			log.Fatal(err)
+		}
 	}
 }
 
-func exportFromPprof(inputName string, benchmark string, granularityOptions []string) {
+// benchPath returns the upload path segment identifying a benchmark within
+// the given scope, as returned by exportScope.
+func benchPath(scope string, benchmark string) string {
+	return scope + "bench/" + url.PathEscape(benchmark)
+}
+
+// exportScope returns the upload path prefix of a benchmark, so that
+// benchmarks of the same name in different modules or packages don't
+// collide: nested modules are prefixed by their module path, and packages
+// other than the module root by their path relative to the module. It is
+// empty for the root package of the root module.
+func exportScope(b Benchmark) (scope string) {
+	if b.ModuleDir != "." {
+		scope = "module/" + url.PathEscape(b.Module) + "/"
+	}
+	if pkg := strings.TrimPrefix(b.ModulePackage(), "./"); pkg != "." {
+		scope += "pkg/" + url.PathEscape(pkg) + "/"
+	}
+	return
+}
+
+// exportFromPprof exports the text reports and flamegraph of the given sample
+// type ("" for the profile default) under the profilePath upload path segment
+// of the benchmark, within the scope returned by exportScope. binary, if set,
+// is the executable which wrote the profile. reportDir, if set, is the
+// directory the reports are also written to, as JSON files.
+func exportFromPprof(inputName string, binary string, benchmark string, scope string, profilePath string, sampleType string, granularityOptions []string, reportDir string) error {
+	saveReport := func(name string, v interface{}) error {
+		if reportDir == "" {
+			return nil
+		}
+		if err := writeJSONFile(filepath.Join(reportDir, filepath.FromSlash(profilePath), name+".json"), v); err != nil {
+			return fmt.Errorf("unable to write the %s report of %s: %v", name, benchmark, err)
+		}
+		return nil
+	}
 	if local {
-		err, finalTree := generateFlameGraph(inputName)
+		err, finalTree := generateFlameGraph(binary, inputName, sampleType)
 		if err != nil {
-			log.Fatalf("An Error Occured %v", err)
+			return err
+		}
+		if err := saveReport("flamegraph", finalTree); err != nil {
+			return err
 		}
 		postBody, err := json.Marshal(finalTree)
 		if err != nil {
-			log.Fatalf("An Error Occured %v", err)
+			return err
 		}
 		fmt.Println(string(postBody))
 
 		for _, granularity := range granularityOptions {
-			err, report := generateTextReports(granularity, inputName)
-			if err == nil {
-				var w io.Writer
-				// open output file
-				localExportLogic(w, report)
-			} else {
-				log.Fatal(err)
+			err, report := generateTextReports(granularity, binary, inputName, sampleType)
+			if err != nil {
+				return err
+			}
+			if err := saveReport(granularity, report); err != nil {
+				return err
+			}
+			if reportDir != "" {
+				// Every sample type and granularity has its own file in
+				// the report directory, while --local-filename would only
+				// keep the last one.
+				continue
 			}
+			var w io.Writer
+			// open output file
+			if err := localExportLogic(w, report); err != nil {
+				return err
+			}
+		}
+		if reportDir != "" {
+			log.Printf("Exported the %s reports of %s to %s", profilePath, benchmark, filepath.Join(reportDir, filepath.FromSlash(profilePath)))
 		}
 	} else {
 		for _, granularity := range granularityOptions {
-			err, report := generateTextReports(granularity, inputName)
-			if err == nil {
-				remoteExportLogic(report, benchmark, granularity)
-			} else {
-				log.Fatal(err)
+			err, report := generateTextReports(granularity, binary, inputName, sampleType)
+			if err != nil {
+				return err
+			}
+			if err := saveReport(granularity, report); err != nil {
+				return err
+			}
+			if err := remoteExportLogic(report, benchmark, scope, profilePath, granularity); err != nil {
+				return err
 			}
 		}
-		err, finalTree := generateFlameGraph(inputName)
+		err, finalTree := generateFlameGraph(binary, inputName, sampleType)
 		if err != nil {
-			log.Fatalf("An Error Occured %v", err)
+			return err
+		}
+		if err := saveReport("flamegraph", finalTree); err != nil {
+			return err
+		}
+		if err := remoteFlameGraphExport(finalTree, benchmark, scope, profilePath); err != nil {
+			return err
 		}
-		remoteFlameGraphExport(finalTree, benchmark)
 		log.Printf("Successfully published profile data")
-		link := fmt.Sprintf("%s/gh/%s/%s/commit/%s/bench/%s/cpu", codeperfUrl, gitOrg, gitRepo, gitCommit, benchmark)
+		link := fmt.Sprintf("%s/gh/%s/%s/commit/%s/%s/%s", codeperfUrl, gitOrg, gitRepo, gitCommit, benchPath(scope, benchmark), profilePath)
 		log.Printf(link)
 	}
+	return nil
 }
 
-func remoteFlameGraphExport(tree treeNodeSlice, benchmark string) {
-	postBody, err := json.Marshal(tree)
+// benchmarkMetadata is the exported benchmark metadata: its source metadata
+// along with the extra go test arguments, environment variables, machine and
+// isolation it was run with.
+type benchmarkMetadata struct {
+	Benchmark
+	GoTestArgs  []string     `json:"goTestArgs,omitempty"`
+	Env         []string     `json:"env,omitempty"`
+	Environment *Fingerprint `json:"environment,omitempty"`
+	Isolation   *Isolation   `json:"isolation,omitempty"`
+}
+
+// exportBenchmarkMetadata publishes the benchmark metadata (doc comment,
+// location, body hash and run settings), or prints it when --local is set.
+func exportBenchmarkMetadata(b Benchmark) error {
+	return exportBenchmarkJSON(b, "metadata", benchmarkMetadata{Benchmark: b, GoTestArgs: goTestArgs, Env: testEnv, Environment: fingerprint, Isolation: isolation})
+}
+
+// exportBenchmarkResults publishes the parsed go test results of every run of
+// the benchmark along with their statistics and the environment fingerprint,
+// or prints them when --local is set.
+func exportBenchmarkResults(b Benchmark, results BenchmarkResults) error {
+	return exportBenchmarkJSON(b, "results", struct {
+		BenchmarkResults
+		Environment *Fingerprint `json:"environment,omitempty"`
+	}{results, fingerprint})
+}
+
+// exportBenchmarkTrace publishes the execution trace summary of every run of
+// the benchmark, or prints them when --local is set.
+func exportBenchmarkTrace(b Benchmark, traces []TraceSummary) error {
+	return exportBenchmarkJSON(b, "trace", traces)
+}
+
+// exportBenchmarkJSON posts v as JSON to the given resource of the benchmark,
+// or prints it when --local is set. It is also written to the benchmark
+// directory of the run output, if any.
+func exportBenchmarkJSON(b Benchmark, resource string, v interface{}) error {
+	if output != nil {
+		dir, err := output.benchmarkDir(b)
+		if err == nil {
+			err = writeJSONFile(filepath.Join(dir, resource+".json"), v)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to write the %s of %s: %v", resource, b.RunName(), err)
+		}
+	}
+	postBody, err := json.Marshal(v)
 	if err != nil {
-		log.Fatalf("An Error Occured %v", err)
+		return err
+	}
+	if local {
+		fmt.Println(string(postBody))
+		return nil
 	}
 	responseBody := bytes.NewBuffer(postBody)
-	endPoint := fmt.Sprintf("%s/v1/gh/%s/%s/commit/%s/bench/%s/cpu/flamegraph", codeperfApiUrl, gitOrg, gitRepo, gitCommit, benchmark)
+	endPoint := fmt.Sprintf("%s/v1/gh/%s/%s/commit/%s/%s/%s", codeperfApiUrl, gitOrg, gitRepo, gitCommit, benchPath(exportScope(b), b.RunName()), resource)
 	resp, err := http.Post(endPoint, "application/json", responseBody)
 	//Handle Error
 	if err != nil {
-		log.Fatalf("An Error Occured %v", err)
+		return err
 	}
 	defer resp.Body.Close()
 
 	//Read the response body
 	reply, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 	if resp.StatusCode != 200 {
-		log.Fatalf("An error ocurred while phusing flamegraph data to remote %s. Status code %d. Reply: %s", codeperfApiUrl, resp.StatusCode, string(reply))
+		return fmt.Errorf("an error ocurred while pushing benchmark %s to remote %s.\nEndpoint %s. Status code %d. Reply: %s", resource, codeperfApiUrl, endPoint, resp.StatusCode, string(reply))
 	}
+	return nil
+}
 
+func remoteFlameGraphExport(tree treeNodeSlice, benchmark string, scope string, profilePath string) error {
+	postBody, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	responseBody := bytes.NewBuffer(postBody)
+	endPoint := fmt.Sprintf("%s/v1/gh/%s/%s/commit/%s/%s/%s/flamegraph", codeperfApiUrl, gitOrg, gitRepo, gitCommit, benchPath(scope, benchmark), profilePath)
+	resp, err := http.Post(endPoint, "application/json", responseBody)
+	//Handle Error
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	//Read the response body
+	reply, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("an error ocurred while phusing flamegraph data to remote %s. Status code %d. Reply: %s", codeperfApiUrl, resp.StatusCode, string(reply))
+	}
+	return nil
 }
 
-func remoteExportLogic(report TextReport, benchmark string, granularity string) {
+func remoteExportLogic(report TextReport, benchmark string, scope string, profilePath string, granularity string) error {
 	postBody, err := json.Marshal(report)
 	if err != nil {
-		log.Fatalf("An Error Occured %v", err)
+		return err
 	}
 	responseBody := bytes.NewBuffer(postBody)
-	endPoint := fmt.Sprintf("%s/v1/gh/%s/%s/commit/%s/bench/%s/cpu/%s", codeperfApiUrl, gitOrg, gitRepo, gitCommit, benchmark, granularity)
+	endPoint := fmt.Sprintf("%s/v1/gh/%s/%s/commit/%s/%s/%s/%s", codeperfApiUrl, gitOrg, gitRepo, gitCommit, benchPath(scope, benchmark), profilePath, granularity)
 	resp, err := http.Post(endPoint, "application/json", responseBody)
 	//Handle Error
 	if err != nil {
-		log.Fatalf("An Error Occured %v", err)
+		return err
 	}
 	defer resp.Body.Close()
 
 	//Read the response body
 	reply, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 	if resp.StatusCode != 200 {
-		log.Fatalf("An error ocurred while pushing cpu data to remote %s.\nEndpoint %s. Status code %d. Reply: %s", codeperfApiUrl, endPoint, resp.StatusCode, string(reply))
+		return fmt.Errorf("an error ocurred while pushing %s data to remote %s.\nEndpoint %s. Status code %d. Reply: %s", profilePath, codeperfApiUrl, endPoint, resp.StatusCode, string(reply))
 	}
+	return nil
 }
 
-func localExportLogic(w io.Writer, report TextReport) {
+func localExportLogic(w io.Writer, report TextReport) (err error) {
 	fo, err := os.Create(localFilename)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	// close fo on exit and check for its returned error
 	defer func() {
-		if err := fo.Close(); err != nil {
-			panic(err)
+		if cerr := fo.Close(); err == nil {
+			err = cerr
 		}
 	}()
 	// make a write buffer
-	w = bufio.NewWriter(fo)
-	enc := json.NewEncoder(w)
-	err = enc.Encode(report)
+	bw := bufio.NewWriter(fo)
+	enc := json.NewEncoder(bw)
+	if err = enc.Encode(report); err == nil {
+		err = bw.Flush()
+	}
 	if err != nil {
-		log.Fatalf("Unable to export the profile to local json. Error: %v", err)
-	} else {
-		log.Printf("Succesfully exported profile to local file %s", localFilename)
+		return fmt.Errorf("unable to export the profile to local json: %v", err)
+	}
+	log.Printf("Succesfully exported profile to local file %s", localFilename)
+	return nil
+}
+
+// mergeProfiles merges the given pprof profiles, e.g. those of repeated runs
+// of a benchmark, into output.
+func mergeProfiles(inputs []string, output string) error {
+	var profiles []*profile.Profile
+	for _, input := range inputs {
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		p, err := profile.Parse(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("cannot parse %s: %v", input, err)
+		}
+		profiles = append(profiles, p)
+	}
+	merged, err := profile.Merge(profiles)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
+	return merged.Write(f)
 }
 
-func generateFlameGraph(input string) (err error, tree treeNodeSlice) {
+// pprofArgs returns the pprof arguments reading the given profile. Passing
+// the binary lets pprof symbolize and disassemble from it.
+func pprofArgs(binary string, input string) []string {
+	if binary == "" {
+		return []string{input}
+	}
+	return []string{binary, input}
+}
+
+func generateFlameGraph(binary string, input string, sampleType string) (err error, tree treeNodeSlice) {
 	f := baseFlags()
 
 	// Read the profile from the encoded protobuf
 	outputTempFile, err := ioutil.TempFile("", "profile_output")
 	if err != nil {
-		log.Fatalf("cannot create tempfile: %v", err)
+		err = fmt.Errorf("cannot create tempfile: %v", err)
+		return
 	}
-	log.Printf("Generating temp file %s", outputTempFile.Name())
-	//defer os.Remove(outputTempFile.Name())
+	defer os.Remove(outputTempFile.Name())
 	defer outputTempFile.Close()
 	f.strings["output"] = outputTempFile.Name()
 	f.bools["proto"] = true
 	f.bools["text"] = false
-	f.args = []string{
-		input,
+	if f.strings["sample_index"], err = pprofSampleIndex(input, sampleType); err != nil {
+		return
 	}
+	f.args = pprofArgs(binary, input)
 	reader := bufio.NewReader(os.Stdin)
 	options := &driver.Options{
 		Flagset: f,
@@ -181,40 +361,71 @@ func generateFlameGraph(input string) (err error, tree treeNodeSlice) {
 	}
 
 	if err = driver.PProf(options); err != nil {
-		log.Fatalf("cannot read pprof profile from %s. Error: %v", input, err)
+		err = fmt.Errorf("cannot read pprof profile from %s: %v", input, err)
 		return
 	}
 
 	file, err := os.Open(outputTempFile.Name())
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
 	defer file.Close()
 	r := bufio.NewReader(file)
 	profile, err := profile.Parse(r)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
-	tree = profileToFolded(profile)
+	sampleIndex := 0
+	if sampleType != "" {
+		if sampleIndex, err = profile.SampleIndexByName(sampleType); err != nil {
+			return
+		}
+	}
+	tree, err = profileToFolded(profile, sampleIndex)
 	return
 }
 
-func generateTextReports(granularity string, input string) (err error, report TextReport) {
+// pprofSampleIndex returns the pprof sample_index option selecting the given
+// sample type, or the profile default for "". The pprof driver keeps the
+// sample index of the previous report unless set, so it must always be set
+// explicitly when reporting on profiles of different kinds.
+func pprofSampleIndex(input string, sampleType string) (string, error) {
+	if sampleType != "" {
+		return sampleType, nil
+	}
+	f, err := os.Open(input)
+	if err != nil {
+		return "", fmt.Errorf("cannot read pprof profile from %s: %v", input, err)
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		return "", fmt.Errorf("cannot read pprof profile from %s: %v", input, err)
+	}
+	if p.DefaultSampleType != "" || len(p.SampleType) == 0 {
+		return p.DefaultSampleType, nil
+	}
+	return p.SampleType[len(p.SampleType)-1].Type, nil
+}
+
+func generateTextReports(granularity string, binary string, input string, sampleType string) (err error, report TextReport) {
 	f := baseFlags()
 
 	// Read the profile from the encoded protobuf
 	outputTempFile, err := ioutil.TempFile("", "profile_output")
 	if err != nil {
-		log.Fatalf("cannot create tempfile: %v", err)
+		err = fmt.Errorf("cannot create tempfile: %v", err)
+		return
 	}
 	defer os.Remove(outputTempFile.Name())
 	defer outputTempFile.Close()
 	f.strings["output"] = outputTempFile.Name()
 	f.bools["text"] = true
 	f.bools[granularity] = true
-	f.args = []string{
-		input,
+	if f.strings["sample_index"], err = pprofSampleIndex(input, sampleType); err != nil {
+		return
 	}
+	f.args = pprofArgs(binary, input)
 	reader := bufio.NewReader(os.Stdin)
 	options := &driver.Options{
 		Flagset: f,
@@ -222,13 +433,13 @@ func generateTextReports(granularity string, input string) (err error, report Te
 	}
 
 	if err = driver.PProf(options); err != nil {
-		log.Fatalf("cannot read pprof profile from %s. Error: %v", input, err)
+		err = fmt.Errorf("cannot read pprof profile from %s: %v", input, err)
 		return
 	}
 
 	file, err := os.Open(outputTempFile.Name())
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
 	defer file.Close()
 	r := bufio.NewReader(file)
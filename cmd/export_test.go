@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_benchPath(t *testing.T) {
+	tests := []struct {
+		name string
+		b    Benchmark
+		want string
+	}{
+		{"root package", Benchmark{Name: "BenchmarkGet", Dir: ".", ModuleDir: ".", Module: "example.com/mono"}, "bench/BenchmarkGet"},
+		{"package", Benchmark{Name: "BenchmarkGet", Dir: "internal/store", ModuleDir: ".", Module: "example.com/mono"}, "pkg/internal%2Fstore/bench/BenchmarkGet"},
+		{"nested module root", Benchmark{Name: "BenchmarkGet", Dir: "nested", ModuleDir: "nested", Module: "example.com/nested"}, "module/example.com%2Fnested/bench/BenchmarkGet"},
+		{"nested module package", Benchmark{Name: "BenchmarkGet", Sub: "small", Dir: "nested/a", ModuleDir: "nested", Module: "example.com/nested"}, "module/example.com%2Fnested/pkg/a/bench/BenchmarkGet%2Fsmall"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := benchPath(exportScope(tt.b), tt.b.RunName()); got != tt.want {
+				t.Errorf("benchPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_pprofSampleIndex(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, defaultSampleType string) string {
+		p := testMemProfile()
+		p.DefaultSampleType = defaultSampleType
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := p.Write(f); err != nil {
+			t.Fatal(err)
+		}
+		return f.Name()
+	}
+	withDefault := write("default.out", "alloc_objects")
+	withoutDefault := write("last.out", "")
+	tests := []struct {
+		name       string
+		input      string
+		sampleType string
+		want       string
+	}{
+		{"requested", withDefault, "alloc_space", "alloc_space"},
+		{"default sample type", withDefault, "", "alloc_objects"},
+		{"last sample type", withoutDefault, "", "alloc_space"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pprofSampleIndex(tt.input, tt.sampleType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("pprofSampleIndex() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if _, err := pprofSampleIndex(filepath.Join(dir, "missing.out"), ""); err == nil {
+		t.Errorf("pprofSampleIndex() of a missing profile returned no error")
+	}
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// benchFilter selects benchmarks by name the same way go test -bench and
+// -skip do: the expressions are split by slashes and each element is matched
+// against the corresponding element of the Parent/Child benchmark name.
+type benchFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func compileBenchExpr(expr string) (res []*regexp.Regexp, err error) {
+	if expr == "" {
+		return
+	}
+	for _, part := range strings.Split(expr, "/") {
+		re, err := regexp.Compile(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid benchmark expression %q: %v", expr, err)
+		}
+		res = append(res, re)
+	}
+	return
+}
+
+func newBenchFilter(include string, exclude string) (f benchFilter, err error) {
+	if f.include, err = compileBenchExpr(include); err != nil {
+		return
+	}
+	f.exclude, err = compileBenchExpr(exclude)
+	return
+}
+
+// match reports whether the benchmark with the given full name is selected.
+// Names with fewer elements than the include expression match partially, so
+// that parents can be selected before their sub-benchmarks are known.
+func (f benchFilter) match(name string) bool {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		if i < len(f.include) && !f.include[i].MatchString(part) {
+			return false
+		}
+	}
+	if len(f.exclude) == 0 || len(parts) < len(f.exclude) {
+		return true
+	}
+	for i, re := range f.exclude {
+		if !re.MatchString(parts[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPackagePattern reports whether the package of the benchmark matches a
+// go test style package pattern. Relative patterns (./..., ./pkg/store/...)
+// are matched against the package directory, any other pattern against the
+// import path.
+func matchPackagePattern(pattern string, b Benchmark) bool {
+	target := b.ImportPath
+	if pattern == "." || strings.HasPrefix(pattern, "./") {
+		target = b.Package()
+	}
+	re := regexp.QuoteMeta(pattern)
+	re = strings.ReplaceAll(re, `\.\.\.`, `.*`)
+	// Special case: foo/... matches foo too.
+	if strings.HasSuffix(re, `/.*`) {
+		re = strings.TrimSuffix(re, `/.*`) + `(/.*)?`
+	}
+	return regexp.MustCompile(`^` + re + `$`).MatchString(target)
+}
+
+// filterBenchmarks keeps the benchmarks matching at least one of the package
+// patterns and selected by the name filter.
+func filterBenchmarks(benchmarks []Benchmark, patterns []string, f benchFilter) (selected []Benchmark) {
+	for _, benchmark := range benchmarks {
+		if !f.match(benchmark.FullName()) {
+			continue
+		}
+		for _, pattern := range patterns {
+			if matchPackagePattern(pattern, benchmark) {
+				selected = append(selected, benchmark)
+				break
+			}
+		}
+	}
+	return
+}
+
+// benchPattern returns the go test -bench expression selecting exactly the
+// given benchmark: every element of its Parent/Child name is escaped and
+// anchored, so that BenchmarkGet does not also select BenchmarkGetAll.
+func benchPattern(b Benchmark) string {
+	parts := strings.Split(b.FullName(), "/")
+	for i, part := range parts {
+		parts[i] = "^" + regexp.QuoteMeta(part) + "$"
+	}
+	return strings.Join(parts, "/")
+}
+
+// procsSuffixRegExp matches the -GOMAXPROCS suffix go test appends to
+// benchmark names when GOMAXPROCS is greater than one.
+var procsSuffixRegExp = regexp.MustCompile(`-\d+$`)
+
+// verifyBenchmarkRan checks from the go test output that the given benchmark,
+// and only it, reported results. Results of its sub-benchmarks count as its
+// own, as a benchmark calling b.Run only reports those of its children.
+func verifyBenchmarkRan(output []byte, b Benchmark) error {
+	ran := false
+	var unexpected []string
+	for _, name := range parseBenchmarkNames(output) {
+		if ranAs(name, b.FullName()) || ranAs(procsSuffixRegExp.ReplaceAllString(name, ""), b.FullName()) {
+			ran = true
+			continue
+		}
+		unexpected = append(unexpected, name)
+	}
+	if len(unexpected) > 0 {
+		return fmt.Errorf("expected only %s to run but the following benchmarks also ran: %s", b.FullName(), strings.Join(unexpected, ", "))
+	}
+	if !ran {
+		return fmt.Errorf("benchmark %s did not report any results", b.FullName())
+	}
+	return nil
+}
+
+// ranAs reports whether the benchmark result name belongs to the benchmark of
+// the given full name or to one of its sub-benchmarks.
+func ranAs(name string, fullName string) bool {
+	return name == fullName || strings.HasPrefix(name, fullName+"/")
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import "testing"
+
+func Test_matchPackagePattern(t *testing.T) {
+	root := Benchmark{Dir: ".", ImportPath: "example.com/mono"}
+	store := Benchmark{Dir: "pkg/store", ImportPath: "example.com/mono/pkg/store"}
+	storeCache := Benchmark{Dir: "pkg/store/cache", ImportPath: "example.com/mono/pkg/store/cache"}
+	storage := Benchmark{Dir: "pkg/storage", ImportPath: "example.com/mono/pkg/storage"}
+	tests := []struct {
+		name    string
+		pattern string
+		b       Benchmark
+		want    bool
+	}{
+		{"all-root", "./...", root, true},
+		{"all-nested", "./...", storeCache, true},
+		{"dot-root", ".", root, true},
+		{"dot-nested", ".", store, false},
+		{"subtree-self", "./pkg/store/...", store, true},
+		{"subtree-child", "./pkg/store/...", storeCache, true},
+		{"subtree-sibling-prefix", "./pkg/store/...", storage, false},
+		{"exact", "./pkg/store", store, true},
+		{"exact-child", "./pkg/store", storeCache, false},
+		{"import-path", "example.com/mono/pkg/...", storage, true},
+		{"import-path-other", "example.com/other/...", storage, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchPackagePattern(tt.pattern, tt.b); got != tt.want {
+				t.Errorf("matchPackagePattern() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_benchFilter_match(t *testing.T) {
+	tests := []struct {
+		name    string
+		include string
+		exclude string
+		bench   string
+		want    bool
+	}{
+		{"no-filter", "", "", "BenchmarkGet", true},
+		{"include", "Get", "", "BenchmarkGet", true},
+		{"include-miss", "Put", "", "BenchmarkGet", false},
+		{"include-parent-partial", "Table/small", "", "BenchmarkTable", true},
+		{"include-sub", "Table/small", "", "BenchmarkTable/small", true},
+		{"include-sub-miss", "Table/small", "", "BenchmarkTable/large", false},
+		{"exclude", "", "GetAll", "BenchmarkGetAll", false},
+		{"exclude-miss", "", "GetAll", "BenchmarkGet", true},
+		{"exclude-sub-keeps-parent", "", "Table/large", "BenchmarkTable", true},
+		{"exclude-sub", "", "Table/large", "BenchmarkTable/large", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newBenchFilter(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.match(tt.bench); got != tt.want {
+				t.Errorf("benchFilter.match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_benchPattern(t *testing.T) {
+	tests := []struct {
+		name string
+		b    Benchmark
+		want string
+	}{
+		{"top-level", Benchmark{Name: "BenchmarkGet"}, "^BenchmarkGet$"},
+		{"sub", Benchmark{Name: "BenchmarkTable", Sub: "size=1.5"}, `^BenchmarkTable$/^size=1\.5$`},
+		{"nested", Benchmark{Name: "BenchmarkTable", Sub: "a/(b)#01"}, `^BenchmarkTable$/^a$/^\(b\)#01$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := benchPattern(tt.b); got != tt.want {
+				t.Errorf("benchPattern() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_verifyBenchmarkRan(t *testing.T) {
+	get := Benchmark{Name: "BenchmarkGet"}
+	tests := []struct {
+		name    string
+		output  string
+		wantErr bool
+	}{
+		{"ran", "BenchmarkGet-8   \t 1000\t 1234 ns/op\nPASS\n", false},
+		{"ran-no-procs-suffix", "BenchmarkGet   \t 1000\t 1234 ns/op\nPASS\n", false},
+		{"not-ran", "PASS\nok  \texample.com/mono\t0.010s\n", true},
+		{"prefix-collision", "BenchmarkGet-8   \t 1000\t 1234 ns/op\nBenchmarkGetAll-8   \t 10\t 4321 ns/op\n", true},
+		{"unexpanded-parent", "BenchmarkGet/small-8   \t 1000\t 1234 ns/op\nBenchmarkGet/large_input-8   \t 10\t 4321 ns/op\n", false},
+		{"sibling-sub-benchmark", "BenchmarkGetAll/small-8   \t 1000\t 1234 ns/op\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyBenchmarkRan([]byte(tt.output), get); (err != nil) != tt.wantErr {
+				t.Errorf("verifyBenchmarkRan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
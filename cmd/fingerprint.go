@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Fingerprint describes the machine and toolchain benchmarks run on, so that
+// results are only compared between like-for-like environments. Fields which
+// cannot be read on the current platform are left empty.
+type Fingerprint struct {
+	CPUModel string `json:"cpuModel,omitempty"`
+	// CPUCores and CPUThreads count the physical cores and logical CPUs.
+	CPUCores    int    `json:"cpuCores"`
+	CPUThreads  int    `json:"cpuThreads"`
+	CPUGovernor string `json:"cpuGovernor,omitempty"`
+	Kernel      string `json:"kernel,omitempty"`
+	MemoryBytes uint64 `json:"memoryBytes,omitempty"`
+	GoVersion   string `json:"goVersion"`
+	GOOS        string `json:"goos"`
+	GOARCH      string `json:"goarch"`
+	// GOMAXPROCS is the default GOMAXPROCS of the benchmarks, unless set by
+	// --cpu.
+	GOMAXPROCS int `json:"gomaxprocs"`
+	// CgroupCPUQuota is the number of CPUs the cgroup quota allows, zero when
+	// unlimited.
+	CgroupCPUQuota float64 `json:"cgroupCpuQuota,omitempty"`
+	// HardwareID is a hash of the hardware fields, identifying runs made on
+	// like-for-like machines.
+	HardwareID string `json:"hardwareId"`
+}
+
+// fingerprint is the environment of the current test run.
+var fingerprint *Fingerprint
+
+func readTrimmed(name string) string {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// parseCPUInfo returns the CPU model, physical core and logical CPU counts
+// from the content of /proc/cpuinfo.
+func parseCPUInfo(cpuinfo string) (model string, cores int, threads int) {
+	coreIDs := map[string]bool{}
+	physicalID := ""
+	scanner := bufio.NewScanner(strings.NewReader(cpuinfo))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch key {
+		case "processor":
+			threads++
+		case "model name", "Hardware":
+			if model == "" {
+				model = value
+			}
+		case "physical id":
+			physicalID = value
+		case "core id":
+			coreIDs[physicalID+"/"+value] = true
+		}
+	}
+	cores = len(coreIDs)
+	if cores == 0 {
+		cores = threads
+	}
+	return
+}
+
+// parseMeminfo returns the value in bytes of the given key, e.g. MemTotal,
+// from the content of /proc/meminfo. It is zero when missing.
+func parseMeminfo(meminfo string, key string) uint64 {
+	scanner := bufio.NewScanner(strings.NewReader(meminfo))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == key+":" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err == nil {
+				return kb * 1024
+			}
+		}
+	}
+	return 0
+}
+
+// parseCPUQuota returns the number of CPUs allowed by a cgroup v2 cpu.max
+// content ("<quota> <period>" or "max <period>"), zero when unlimited.
+func parseCPUQuota(cpuMax string) float64 {
+	fields := strings.Fields(cpuMax)
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0
+	}
+	return cfsQuota(fields[0], fields[1])
+}
+
+// cfsQuota returns quota/period, zero when unlimited or invalid.
+func cfsQuota(quota string, period string) float64 {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return q / p
+}
+
+// cgroupCPUDir returns the directory of the cgroup CPU controller of the
+// current process, and whether it belongs to a cgroup v2 hierarchy. The
+// directory is empty when unknown.
+func cgroupCPUDir() (dir string, v2 bool) {
+	scanner := bufio.NewScanner(strings.NewReader(readTrimmed("/proc/self/cgroup")))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		controllers, cgroup := parts[1], parts[2]
+		if controllers == "" {
+			for _, root := range []string{path.Join("/sys/fs/cgroup", cgroup), "/sys/fs/cgroup"} {
+				if _, err := os.Stat(path.Join(root, "cpu.stat")); err == nil {
+					return root, true
+				}
+			}
+			continue
+		}
+		for _, controller := range strings.Split(controllers, ",") {
+			if controller != "cpu" {
+				continue
+			}
+			for _, root := range []string{path.Join("/sys/fs/cgroup", controllers, cgroup), path.Join("/sys/fs/cgroup", controllers), "/sys/fs/cgroup/cpu"} {
+				if _, err := os.Stat(path.Join(root, "cpu.cfs_quota_us")); err == nil {
+					return root, false
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// cgroupCPUQuota returns the number of CPUs allowed by the cgroup of the
+// current process, for cgroup v2 and v1, zero when unlimited or unknown.
+func cgroupCPUQuota() float64 {
+	dir, v2 := cgroupCPUDir()
+	switch {
+	case dir == "":
+		return 0
+	case v2:
+		return parseCPUQuota(readTrimmed(path.Join(dir, "cpu.max")))
+	default:
+		return cfsQuota(readTrimmed(path.Join(dir, "cpu.cfs_quota_us")), readTrimmed(path.Join(dir, "cpu.cfs_period_us")))
+	}
+}
+
+// defaultGOMAXPROCS returns the GOMAXPROCS the benchmarks run with when not
+// set by --cpu: that of the GOMAXPROCS environment variable given by --env,
+// else the number of CPUs given by --cpuset, else the one the runtime picks on
+// this machine.
+func defaultGOMAXPROCS() int {
+	for _, kv := range testEnv {
+		if value := strings.TrimPrefix(kv, "GOMAXPROCS="); value != kv {
+			if n, err := strconv.Atoi(value); err == nil && n > 0 {
+				return n
+			}
+		}
+	}
+	if isolation != nil && len(isolation.CPUs) > 0 {
+		return len(isolation.CPUs)
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// collectFingerprint reads the environment fingerprint from /proc, /sys and
+// go env.
+func collectFingerprint(goPath string) (*Fingerprint, error) {
+	f := &Fingerprint{
+		CPUGovernor:    readTrimmed("/sys/devices/system/cpu/cpu0/cpufreq/scaling_governor"),
+		Kernel:         readTrimmed("/proc/sys/kernel/osrelease"),
+		MemoryBytes:    parseMeminfo(readTrimmed("/proc/meminfo"), "MemTotal"),
+		GOMAXPROCS:     defaultGOMAXPROCS(),
+		CgroupCPUQuota: cgroupCPUQuota(),
+	}
+	f.CPUModel, f.CPUCores, f.CPUThreads = parseCPUInfo(readTrimmed("/proc/cpuinfo"))
+	if f.CPUThreads == 0 {
+		f.CPUThreads = runtime.NumCPU()
+		f.CPUCores = f.CPUThreads
+	}
+	c := exec.Command(goPath, "env", "GOVERSION", "GOOS", "GOARCH")
+	c.Env = commandEnv()
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go env failed: %v", err)
+	}
+	values := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(values) != 3 {
+		return nil, fmt.Errorf("unexpected go env output: %q", out)
+	}
+	f.GoVersion, f.GOOS, f.GOARCH = values[0], values[1], values[2]
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d\n%d\n%d\n%s\n%g\n", f.CPUModel, f.CPUCores, f.CPUThreads, f.MemoryBytes, f.GOARCH, f.CgroupCPUQuota)
+	f.HardwareID = hex.EncodeToString(h.Sum(nil))[:16]
+	return f, nil
+}
+
+func (f Fingerprint) String() string {
+	s := fmt.Sprintf("%s, %d cores, %d threads, %d MiB, %s %s/%s, GOMAXPROCS=%d", f.CPUModel, f.CPUCores, f.CPUThreads, f.MemoryBytes>>20, f.GoVersion, f.GOOS, f.GOARCH, f.GOMAXPROCS)
+	if f.CPUGovernor != "" {
+		s += ", governor " + f.CPUGovernor
+	}
+	if f.CgroupCPUQuota > 0 {
+		s += fmt.Sprintf(", cgroup quota %g CPUs", f.CgroupCPUQuota)
+	}
+	return s
+}
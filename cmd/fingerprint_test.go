@@ -0,0 +1,67 @@
+package cmd
+
+import "testing"
+
+func Test_parseCPUInfo(t *testing.T) {
+	tests := []struct {
+		name        string
+		cpuinfo     string
+		wantModel   string
+		wantCores   int
+		wantThreads int
+	}{
+		{"empty", "", "", 0, 0},
+		{
+			"hyperthreads",
+			"processor\t: 0\nmodel name\t: Intel(R) Xeon(R) CPU\nphysical id\t: 0\ncore id\t\t: 0\n\n" +
+				"processor\t: 1\nmodel name\t: Intel(R) Xeon(R) CPU\nphysical id\t: 0\ncore id\t\t: 0\n\n" +
+				"processor\t: 2\nmodel name\t: Intel(R) Xeon(R) CPU\nphysical id\t: 1\ncore id\t\t: 0\n",
+			"Intel(R) Xeon(R) CPU", 2, 3,
+		},
+		{"no topology", "processor\t: 0\nHardware\t: BCM2835\n\nprocessor\t: 1\n", "BCM2835", 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, cores, threads := parseCPUInfo(tt.cpuinfo)
+			if model != tt.wantModel || cores != tt.wantCores || threads != tt.wantThreads {
+				t.Errorf("parseCPUInfo() = %q, %d, %d, want %q, %d, %d", model, cores, threads, tt.wantModel, tt.wantCores, tt.wantThreads)
+			}
+		})
+	}
+}
+
+func Test_parseMeminfo(t *testing.T) {
+	meminfo := "MemTotal:       16303968 kB\nMemFree:         1000 kB\nMemAvailable:    8000 kB\n"
+	tests := []struct {
+		key  string
+		want uint64
+	}{
+		{"MemTotal", 16303968 * 1024},
+		{"MemAvailable", 8000 * 1024},
+		{"Mem", 0},
+		{"SwapTotal", 0},
+	}
+	for _, tt := range tests {
+		if got := parseMeminfo(meminfo, tt.key); got != tt.want {
+			t.Errorf("parseMeminfo(%q) = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+}
+
+func Test_parseCPUQuota(t *testing.T) {
+	tests := []struct {
+		cpuMax string
+		want   float64
+	}{
+		{"max 100000", 0},
+		{"200000 100000", 2},
+		{"50000 100000", 0.5},
+		{"", 0},
+		{"-1 100000", 0},
+	}
+	for _, tt := range tests {
+		if got := parseCPUQuota(tt.cpuMax); got != tt.want {
+			t.Errorf("parseCPUQuota(%q) = %g, want %g", tt.cpuMax, got, tt.want)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"github.com/google/pprof/profile"
-	"log"
 	"regexp"
 	"sort"
 	"strings"
@@ -52,23 +51,20 @@ func ShortenFunctionName(f string) string {
 	return f
 }
 
-// Convert marshals the given protobuf profile into folded text format.
-func profileToFolded(protobuf *profile.Profile) treeNodeSlice {
+// Convert marshals the given protobuf profile into folded text format, using
+// the values of the sample type at sampleIndex.
+func profileToFolded(protobuf *profile.Profile, sampleIndex int) (treeNodeSlice, error) {
 	rootNode := treeNode{"root", "root", 0, make(map[string]*treeNode, 0)}
 	if err := protobuf.Aggregate(true, true, false, false, false); err != nil {
-		log.Fatal(err)
+		return treeNodeSlice{}, err
 	}
 	protobuf = protobuf.Compact()
 	sort.Slice(protobuf.Sample, func(i, j int) bool {
-		return protobuf.Sample[i].Value[0] > protobuf.Sample[j].Value[0]
+		return protobuf.Sample[i].Value[sampleIndex] > protobuf.Sample[j].Value[sampleIndex]
 	})
 
 	for _, sample := range protobuf.Sample {
-		var cum int64 = 0
-		for _, val := range sample.Value {
-			cum = cum + val
-			break
-		}
+		var cum int64 = sample.Value[sampleIndex]
 		var frames []string
 		var currentNode *treeNode
 		var currentMap map[string]*treeNode = rootNode.Children
@@ -91,7 +87,7 @@ func profileToFolded(protobuf *profile.Profile) treeNodeSlice {
 		}
 	}
 	finalTree := treeNodeSlice{rootNode.Name, rootNode.FullName, rootNode.Cum, collapse(rootNode.Children)}
-	return finalTree
+	return finalTree, nil
 }
 
 func collapse(children map[string]*treeNode) (tree []treeNodeSlice) {
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+// testMemProfile returns a profile with two sample types, where get
+// allocates the most objects and put the most space.
+func testMemProfile() *profile.Profile {
+	get := &profile.Function{ID: 1, Name: "example.com/a.get", SystemName: "example.com/a.get"}
+	put := &profile.Function{ID: 2, Name: "example.com/a.put", SystemName: "example.com/a.put"}
+	getLoc := &profile.Location{ID: 1, Address: 0x10, Line: []profile.Line{{Function: get, Line: 3}}}
+	putLoc := &profile.Location{ID: 2, Address: 0x20, Line: []profile.Line{{Function: put, Line: 7}}}
+	return &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "alloc_objects", Unit: "count"}, {Type: "alloc_space", Unit: "bytes"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{getLoc}, Value: []int64{10, 80}},
+			{Location: []*profile.Location{putLoc}, Value: []int64{1, 4096}},
+		},
+		Location: []*profile.Location{getLoc, putLoc},
+		Function: []*profile.Function{get, put},
+	}
+}
+
+func Test_profileToFolded(t *testing.T) {
+	tests := []struct {
+		sampleType string
+		want       map[string]int64
+	}{
+		{"alloc_objects", map[string]int64{"get": 10, "put": 1}},
+		{"alloc_space", map[string]int64{"get": 80, "put": 4096}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sampleType, func(t *testing.T) {
+			p := testMemProfile()
+			sampleIndex, err := p.SampleIndexByName(tt.sampleType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree, err := profileToFolded(p, sampleIndex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]int64{}
+			for _, child := range tree.Children {
+				got[child.Name] = child.Cum
+			}
+			names := make([]string, 0, len(got))
+			for name := range got {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if len(got) != len(tt.want) {
+				t.Fatalf("profileToFolded() children = %q, want %d", names, len(tt.want))
+			}
+			for name, cum := range tt.want {
+				if got[name] != cum {
+					t.Errorf("profileToFolded() %s = %d, want %d", name, got[name], cum)
+				}
+			}
+		})
+	}
+}
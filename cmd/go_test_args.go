@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"os"
+	"strings"
+)
+
+// goTestArgs and testEnv are set by the --go-test-arg and --env flags, or
+// the test-go-args and test-env config keys.
+var goTestArgs []string
+var testEnv []string
+
+// Config keys of the lists defaulting --go-test-arg and --env.
+const (
+	goTestArgsConfigKey = "test-go-args"
+	testEnvConfigKey    = "test-env"
+)
+
+// configStringSlice returns the list set for the key in the config file, if
+// any. Unlike viper.IsSet, it ignores the environment variables bound by
+// viper.AutomaticEnv, e.g. ENV exported by many shells for env.
+func configStringSlice(key string) ([]string, bool) {
+	if !viper.InConfig(key) {
+		return nil, false
+	}
+	return viper.GetStringSlice(key), true
+}
+
+// passThrough holds goTestArgs once parsed.
+var passThrough goTestFlags
+
+// goTestFlags holds extra go test flags, split by the step they apply to.
+type goTestFlags struct {
+	// Build holds the build flags, passed to go test -c.
+	Build []string
+	// Run holds the test binary flags, in their -test. prefixed form.
+	Run []string
+}
+
+// controlledGoTestFlags lists the go test flags set by codeperf itself, along
+// with the codeperf flag to use instead, if any.
+var controlledGoTestFlags = map[string]string{
+	"bench":                "--bench",
+	"benchtime":            "--benchtime",
+	"blockprofile":         "--profiles",
+	"blockprofilerate":     "--blockprofilerate",
+	"c":                    "",
+	"count":                "--count",
+	"coverprofile":         "",
+	"cpu":                  "--cpu",
+	"cpuprofile":           "--profiles",
+	"exec":                 "",
+	"fuzz":                 "",
+	"json":                 "",
+	"list":                 "",
+	"memprofile":           "--profiles",
+	"mutexprofile":         "--profiles",
+	"mutexprofilefraction": "--mutexprofilefraction",
+	"o":                    "",
+	"outputdir":            "--output-dir",
+	"run":                  "",
+	"skip":                 "--skip-bench",
+	"tags":                 "--tags",
+	"trace":                "--trace",
+}
+
+// testBinaryFlags lists the go test flags handled by the test binary, and
+// whether they take a value.
+var testBinaryFlags = map[string]bool{
+	"benchmem":       false,
+	"failfast":       false,
+	"fullpath":       false,
+	"memprofilerate": true,
+	"parallel":       true,
+	"short":          false,
+	"shuffle":        true,
+	"timeout":        true,
+	"v":              false,
+}
+
+// goBuildFlags lists the go test build flags, and whether they take a value.
+var goBuildFlags = map[string]bool{
+	"a":             false,
+	"asan":          false,
+	"asmflags":      true,
+	"buildmode":     true,
+	"buildvcs":      true,
+	"compiler":      true,
+	"cover":         false,
+	"covermode":     true,
+	"coverpkg":      true,
+	"gccgoflags":    true,
+	"gcflags":       true,
+	"installsuffix": true,
+	"ldflags":       true,
+	"linkshared":    false,
+	"mod":           true,
+	"modcacherw":    false,
+	"modfile":       true,
+	"msan":          false,
+	"overlay":       true,
+	"p":             true,
+	"pgo":           true,
+	"pkgdir":        true,
+	"race":          false,
+	"toolexec":      true,
+	"trimpath":      false,
+	"work":          false,
+	"x":             false,
+}
+
+// parseGoTestArgs validates extra go test arguments and splits them into
+// build and test binary flags. Values are given either as -flag=value or as
+// the next argument.
+func parseGoTestArgs(args []string) (flags goTestFlags, err error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+			return flags, fmt.Errorf("unexpected argument %q, only go test flags can be passed through", arg)
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		value, hasValue := "", false
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value, hasValue = name[:eq], name[eq+1:], true
+		}
+		if replacement, ok := controlledGoTestFlags[name]; ok {
+			if replacement != "" {
+				return flags, fmt.Errorf("-%s is set by codeperf, use %s instead", name, replacement)
+			}
+			return flags, fmt.Errorf("-%s is set by codeperf and cannot be passed through", name)
+		}
+		takesValue, isRun := testBinaryFlags[name]
+		if !isRun {
+			var isBuild bool
+			if takesValue, isBuild = goBuildFlags[name]; !isBuild {
+				return flags, fmt.Errorf("unknown go test flag -%s", name)
+			}
+		}
+		if takesValue && !hasValue {
+			if i+1 == len(args) {
+				return flags, fmt.Errorf("missing value for -%s", name)
+			}
+			i++
+			value, hasValue = args[i], true
+		}
+		flag := "-" + name
+		if isRun {
+			flag = "-test." + name
+		}
+		if hasValue {
+			flag += "=" + value
+		}
+		if isRun {
+			flags.Run = append(flags.Run, flag)
+		} else {
+			flags.Build = append(flags.Build, flag)
+		}
+	}
+	return
+}
+
+// validateEnv checks that every environment variable is given as KEY=VALUE.
+func validateEnv(env []string) error {
+	for _, kv := range env {
+		if eq := strings.Index(kv, "="); eq <= 0 {
+			return fmt.Errorf("invalid environment variable %q, expected KEY=VALUE", kv)
+		}
+	}
+	return nil
+}
+
+// commandEnv returns the environment of the go and test binary commands: the
+// codeperf environment extended by testEnv, or nil to inherit it as is.
+func commandEnv() []string {
+	if len(testEnv) == 0 {
+		return nil
+	}
+	return append(os.Environ(), testEnv...)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseGoTestArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    goTestFlags
+		wantErr bool
+	}{
+		{"empty", nil, goTestFlags{}, false},
+		{"run flags", []string{"-benchmem", "-timeout", "30m", "--short", "-v=true"}, goTestFlags{Run: []string{"-test.benchmem", "-test.timeout=30m", "-test.short", "-test.v=true"}}, false},
+		{"build flags", []string{"-race", "-gcflags=all=-N -l", "-mod", "vendor"}, goTestFlags{Build: []string{"-race", "-gcflags=all=-N -l", "-mod=vendor"}}, false},
+		{"mixed", []string{"-trimpath", "-parallel=4"}, goTestFlags{Build: []string{"-trimpath"}, Run: []string{"-test.parallel=4"}}, false},
+		{"controlled with replacement", []string{"-tags", "integration"}, goTestFlags{}, true},
+		{"controlled", []string{"-run=TestX"}, goTestFlags{}, true},
+		{"unknown", []string{"-frobnicate"}, goTestFlags{}, true},
+		{"missing value", []string{"-timeout"}, goTestFlags{}, true},
+		{"positional", []string{"./..."}, goTestFlags{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGoTestArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseGoTestArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseGoTestArgs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     []string
+		wantErr bool
+	}{
+		{"valid", []string{"GOEXPERIMENT=loopvar", "EMPTY="}, false},
+		{"missing value", []string{"GOFLAGS"}, true},
+		{"missing key", []string{"=value"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateEnv(tt.env); (err != nil) != tt.wantErr {
+				t.Errorf("validateEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_configStringSlice(t *testing.T) {
+	defer viper.Reset()
+	t.Setenv("ENV", "/etc/profile")
+	viper.AutomaticEnv()
+	if got, ok := configStringSlice(testEnvConfigKey); ok {
+		t.Errorf("configStringSlice() = %q, want unset without a config file", got)
+	}
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader("test-env:\n  - GOGC=off\ntest-go-args:\n  - -benchmem\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := configStringSlice(testEnvConfigKey); !ok || !reflect.DeepEqual(got, []string{"GOGC=off"}) {
+		t.Errorf("configStringSlice(%s) = %q, %v", testEnvConfigKey, got, ok)
+	}
+	if got, ok := configStringSlice(goTestArgsConfigKey); !ok || !reflect.DeepEqual(got, []string{"-benchmem"}) {
+		t.Errorf("configStringSlice(%s) = %q, %v", goTestArgsConfigKey, got, ok)
+	}
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// cpuset, nice and sched are set by the --cpuset, --nice and --sched flags.
+var cpuset string
+var nice int
+var sched string
+
+// isolation is the isolation applied to the benchmark processes, nil when
+// none is set.
+var isolation *Isolation
+
+// Isolation describes the CPU affinity and scheduling applied to the
+// benchmark processes.
+type Isolation struct {
+	// CPUs lists the CPUs the benchmarks are pinned to, all if empty.
+	CPUs []int `json:"cpus,omitempty"`
+	// Nice is the nice value of the benchmarks, unchanged if zero.
+	Nice int `json:"nice,omitempty"`
+	// Policy and Priority are the scheduling policy and its static priority,
+	// the policy is unchanged if empty.
+	Policy   string `json:"policy,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+// parseCPUSet parses a cpuset(7) style list of CPUs and ranges, e.g. 2-5,8.
+func parseCPUSet(s string) ([]int, error) {
+	seen := map[int]bool{}
+	for _, part := range strings.Split(s, ",") {
+		first, last := part, part
+		if dash := strings.Index(part, "-"); dash >= 0 {
+			first, last = part[:dash], part[dash+1:]
+		}
+		from, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil || from < 0 {
+			return nil, fmt.Errorf("invalid CPU %q in %q", first, s)
+		}
+		to, err := strconv.Atoi(strings.TrimSpace(last))
+		if err != nil || to < from {
+			return nil, fmt.Errorf("invalid CPU range %q in %q", part, s)
+		}
+		for cpu := from; cpu <= to; cpu++ {
+			seen[cpu] = true
+		}
+	}
+	cpus := make([]int, 0, len(seen))
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// schedPriorities holds the range of static priorities of every scheduling
+// policy, see sched(7).
+var schedPriorities = map[string][2]int{
+	"other": {0, 0},
+	"batch": {0, 0},
+	"idle":  {0, 0},
+	"fifo":  {1, 99},
+	"rr":    {1, 99},
+}
+
+// parseSched parses a scheduling policy, optionally followed by its static
+// priority, e.g. fifo:10. The real-time policies default to priority 1.
+func parseSched(s string) (policy string, priority int, err error) {
+	policy = s
+	if colon := strings.Index(s, ":"); colon >= 0 {
+		policy = s[:colon]
+		if priority, err = strconv.Atoi(s[colon+1:]); err != nil {
+			return "", 0, fmt.Errorf("invalid priority in %q", s)
+		}
+	}
+	bounds, ok := schedPriorities[policy]
+	if !ok {
+		return "", 0, fmt.Errorf("unknown scheduling policy %q, expected one of other, batch, idle, fifo or rr", policy)
+	}
+	if !strings.Contains(s, ":") {
+		priority = bounds[0]
+	}
+	if priority < bounds[0] || priority > bounds[1] {
+		return "", 0, fmt.Errorf("the priority of the %s policy must be between %d and %d, got %d", policy, bounds[0], bounds[1], priority)
+	}
+	return
+}
+
+// newIsolation validates the --cpuset, --nice and --sched values, returning
+// nil when none is set.
+func newIsolation(cpuset string, nice int, sched string) (*Isolation, error) {
+	if cpuset == "" && nice == 0 && sched == "" {
+		return nil, nil
+	}
+	i := &Isolation{Nice: nice}
+	if nice < -20 || nice > 19 {
+		return nil, fmt.Errorf("the nice value must be between -20 and 19, got %d", nice)
+	}
+	var err error
+	if cpuset != "" {
+		if i.CPUs, err = parseCPUSet(cpuset); err != nil {
+			return nil, err
+		}
+	}
+	if sched != "" {
+		if i.Policy, i.Priority, err = parseSched(sched); err != nil {
+			return nil, err
+		}
+	}
+	return i, i.validate()
+}
+
+func (i Isolation) String() string {
+	var parts []string
+	if len(i.CPUs) > 0 {
+		cpus := make([]string, len(i.CPUs))
+		for j, cpu := range i.CPUs {
+			cpus[j] = strconv.Itoa(cpu)
+		}
+		parts = append(parts, "CPUs "+strings.Join(cpus, ","))
+	}
+	if i.Nice != 0 {
+		parts = append(parts, fmt.Sprintf("nice %d", i.Nice))
+	}
+	if i.Policy != "" {
+		parts = append(parts, fmt.Sprintf("%s scheduling with priority %d", i.Policy, i.Priority))
+	}
+	return strings.Join(parts, ", ")
+}
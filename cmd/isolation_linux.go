@@ -0,0 +1,84 @@
+//go:build linux
+
+package cmd
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// schedPolicies maps the scheduling policies to their sched.h value.
+var schedPolicies = map[string]int{
+	"other": 0,
+	"fifo":  1,
+	"rr":    2,
+	"batch": 3,
+	"idle":  5,
+}
+
+// validate checks that the CPUs are allowed by the affinity of codeperf, e.g.
+// by its cgroup cpuset.
+func (i Isolation) validate() error {
+	if len(i.CPUs) == 0 {
+		return nil
+	}
+	var allowed unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &allowed); err != nil {
+		return err
+	}
+	for _, cpu := range i.CPUs {
+		if !allowed.IsSet(cpu) {
+			return fmt.Errorf("CPU %d is not available to codeperf", cpu)
+		}
+	}
+	return nil
+}
+
+// start starts the command with the isolation applied. The affinity, nice
+// value and scheduling policy are set on a dedicated thread which forks the
+// command, so that every thread of the command inherits them from the start.
+// The thread stays locked, for it to exit once done rather than running other
+// goroutines.
+func (i Isolation) start(cmd *exec.Cmd) error {
+	errs := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if err := i.applyToThread(); err != nil {
+			errs <- err
+			return
+		}
+		errs <- cmd.Start()
+	}()
+	return <-errs
+}
+
+// applyToThread applies the isolation to the calling thread.
+func (i Isolation) applyToThread() error {
+	if len(i.CPUs) > 0 {
+		var set unix.CPUSet
+		for _, cpu := range i.CPUs {
+			set.Set(cpu)
+		}
+		if err := unix.SchedSetaffinity(0, &set); err != nil {
+			return fmt.Errorf("unable to set the CPU affinity: %v", err)
+		}
+	}
+	if i.Nice != 0 {
+		// On Linux the nice value is a per thread attribute.
+		if err := unix.Setpriority(unix.PRIO_PROCESS, 0, i.Nice); err != nil {
+			return fmt.Errorf("unable to set the nice value to %d: %v", i.Nice, err)
+		}
+	}
+	if i.Policy != "" {
+		param := struct{ priority int32 }{int32(i.Priority)}
+		_, _, errno := unix.RawSyscall(unix.SYS_SCHED_SETSCHEDULER, 0, uintptr(schedPolicies[i.Policy]), uintptr(unsafe.Pointer(&param)))
+		if errno != 0 {
+			return fmt.Errorf("unable to set the %s scheduling policy: %v", i.Policy, errno)
+		}
+	}
+	return nil
+}
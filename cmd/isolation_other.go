@@ -0,0 +1,18 @@
+//go:build !linux
+
+package cmd
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errIsolationUnsupported = errors.New("--cpuset, --nice and --sched are only supported on Linux")
+
+func (i Isolation) validate() error {
+	return errIsolationUnsupported
+}
+
+func (i Isolation) start(cmd *exec.Cmd) error {
+	return errIsolationUnsupported
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func Test_parseCPUSet(t *testing.T) {
+	tests := []struct {
+		cpuset  string
+		want    []int
+		wantErr bool
+	}{
+		{"2-5", []int{2, 3, 4, 5}, false},
+		{"8,0,2-3,3", []int{0, 2, 3, 8}, false},
+		{"1", []int{1}, false},
+		{"5-2", nil, true},
+		{"a", nil, true},
+		{"1,", nil, true},
+		{"-1", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCPUSet(tt.cpuset)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCPUSet(%q) error = %v, wantErr %v", tt.cpuset, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCPUSet(%q) = %v, want %v", tt.cpuset, got, tt.want)
+		}
+	}
+}
+
+func Test_parseSched(t *testing.T) {
+	tests := []struct {
+		sched        string
+		wantPolicy   string
+		wantPriority int
+		wantErr      bool
+	}{
+		{"batch", "batch", 0, false},
+		{"fifo", "fifo", 1, false},
+		{"rr:50", "rr", 50, false},
+		{"fifo:0", "", 0, true},
+		{"other:10", "", 0, true},
+		{"rr:x", "", 0, true},
+		{"deadline", "", 0, true},
+	}
+	for _, tt := range tests {
+		policy, priority, err := parseSched(tt.sched)
+		if (err != nil) != tt.wantErr || policy != tt.wantPolicy || priority != tt.wantPriority {
+			t.Errorf("parseSched(%q) = %q, %d, %v, want %q, %d, error %v", tt.sched, policy, priority, err, tt.wantPolicy, tt.wantPriority, tt.wantErr)
+		}
+	}
+}
+
+func Test_newIsolation(t *testing.T) {
+	if got, err := newIsolation("", 0, ""); got != nil || err != nil {
+		t.Errorf("newIsolation() = %v, %v, want nil when unset", got, err)
+	}
+	if _, err := newIsolation("", 20, ""); err == nil {
+		t.Error("newIsolation() accepted nice 20")
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("isolation is only supported on Linux")
+	}
+	got, err := newIsolation("", 5, "batch")
+	if err != nil {
+		t.Fatalf("newIsolation() error = %v", err)
+	}
+	if want := (&Isolation{Nice: 5, Policy: "batch"}); !reflect.DeepEqual(got, want) || got.String() != "nice 5, batch scheduling with priority 0" {
+		t.Errorf("newIsolation() = %+v (%s), want %+v", got, got, want)
+	}
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Module describes a Go module found while walking the project.
+type Module struct {
+	// Path is the module path declared in go.mod.
+	Path string `json:"path"`
+	// Dir is the slash separated module root, relative to the project root.
+	Dir string `json:"dir"`
+	// Excluded holds the reason why the module benchmarks are not run, e.g.
+	// when a go.work file exists but does not use the module.
+	Excluded string `json:"excluded,omitempty"`
+}
+
+// modulePath returns the module path declared in the given go.mod file,
+// or an empty string if it cannot be read.
+func modulePath(gomod string) string {
+	f, err := os.Open(gomod)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "module") {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "module")), `"`)
+		}
+	}
+	return ""
+}
+
+// workspaceModules returns the slash separated module directories listed by
+// the use directives of the given go.work file, relative to its directory.
+// ok is false if the file cannot be read.
+func workspaceModules(gowork string) (dirs []string, ok bool) {
+	f, err := os.Open(gowork)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	inUseBlock := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case inUseBlock && line == ")":
+			inUseBlock = false
+			continue
+		case inUseBlock:
+		case line == "use (" || line == "use(":
+			inUseBlock = true
+			continue
+		case strings.HasPrefix(line, "use "):
+			line = strings.TrimSpace(strings.TrimPrefix(line, "use"))
+		default:
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		dirs = append(dirs, path.Clean(filepath.ToSlash(strings.Trim(line, `"`))))
+	}
+	return dirs, true
+}
+
+// moduleSet maps module root directories to the modules rooted there.
+type moduleSet map[string]Module
+
+// lookup returns the module the given slash separated directory belongs to,
+// i.e. the one with the closest root among its ancestors.
+func (m moduleSet) lookup(dir string) (Module, bool) {
+	for {
+		if module, ok := m[dir]; ok {
+			return module, true
+		}
+		if dir == "." {
+			return Module{Dir: "."}, false
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// relDir returns dir relative to the module root.
+func (m Module) relDir(dir string) string {
+	if m.Dir == "." || m.Dir == "" {
+		return dir
+	}
+	if dir == m.Dir {
+		return "."
+	}
+	return strings.TrimPrefix(dir, m.Dir+"/")
+}
+
+// packageImportPath joins the module path and the package directory relative
+// to the module root. Without a module path the relative package pattern is
+// returned instead.
+func packageImportPath(module string, dir string) string {
+	if module == "" {
+		return relPackage(dir)
+	}
+	if dir == "." {
+		return module
+	}
+	return module + "/" + dir
+}
+
+// relPackage returns the ./ prefixed package pattern of a slash separated
+// directory.
+func relPackage(dir string) string {
+	if dir == "." {
+		return "."
+	}
+	return "./" + dir
+}
@@ -0,0 +1,273 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// strict is set by the --strict flag.
+var strict bool
+
+// userHZ is the unit of the CPU times of /proc/stat, in ticks per second. It
+// is 100 on every Linux architecture Go supports.
+const userHZ = 100
+
+// noiseSampleInterval is how often the load average is sampled while a
+// benchmark runs.
+const noiseSampleInterval = time.Second
+
+// noisyRunScore is the noise score above which a run is reported as noisy.
+const noisyRunScore = 0.1
+
+// hostState holds the host conditions checked before running benchmarks.
+type hostState struct {
+	// LoadAvg is the 1-minute load average.
+	LoadAvg float64
+	CPUs    int
+	// Governors counts the CPUs by cpufreq governor.
+	Governors map[string]int
+	Turbo     bool
+	// CPUQuota is the number of CPUs allowed by the cgroup quota, and
+	// Throttled the number of periods it throttled the cgroup so far.
+	CPUQuota     float64
+	Throttled    uint64
+	MemTotal     uint64
+	MemAvailable uint64
+}
+
+// NoiseSample describes how noisy the host was during a benchmark run.
+type NoiseSample struct {
+	// LoadAvg is the highest 1-minute load average seen during the run.
+	LoadAvg float64 `json:"loadAvg"`
+	// ForeignCPU is the share of the CPU time of the machine, or of the CPUs
+	// given by --cpuset, used by other processes than the benchmark.
+	ForeignCPU float64 `json:"foreignCpu"`
+	// Steal is the share of CPU time stolen by the hypervisor.
+	Steal float64 `json:"steal"`
+	// Throttled is the share of cgroup periods throttled by the CPU quota.
+	Throttled float64 `json:"throttled"`
+	// Score sums ForeignCPU, Steal and Throttled: zero on a quiet host, and
+	// growing with the noise.
+	Score float64 `json:"score"`
+}
+
+// cpuTimes holds the aggregated CPU times of the cpu line of /proc/stat, in
+// ticks.
+type cpuTimes struct {
+	Busy  uint64
+	Steal uint64
+	Total uint64
+}
+
+// parseLoadAvg returns the 1-minute load average from the content of
+// /proc/loadavg.
+func parseLoadAvg(loadavg string) float64 {
+	fields := strings.Fields(loadavg)
+	if len(fields) == 0 {
+		return 0
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return load
+}
+
+// parseCPUTimes returns the CPU times of the given CPUs, or of all CPUs if
+// none, from the content of /proc/stat. Guest time is already accounted in
+// user time.
+func parseCPUTimes(stat string, cpus []int) (times cpuTimes) {
+	lines := map[string]bool{}
+	for _, cpu := range cpus {
+		lines[fmt.Sprintf("cpu%d", cpu)] = true
+	}
+	if len(lines) == 0 {
+		lines["cpu"] = true
+	}
+	scanner := bufio.NewScanner(strings.NewReader(stat))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 9 || !lines[fields[0]] {
+			continue
+		}
+		var values [8]uint64
+		for i := range values {
+			values[i], _ = strconv.ParseUint(fields[i+1], 10, 64)
+			times.Total += values[i]
+		}
+		// user nice system idle iowait irq softirq steal
+		times.Busy += values[0] + values[1] + values[2] + values[5] + values[6]
+		times.Steal += values[7]
+	}
+	return
+}
+
+// isolatedCPUs returns the CPUs the benchmarks are pinned to, if any.
+func isolatedCPUs() []int {
+	if isolation == nil {
+		return nil
+	}
+	return isolation.CPUs
+}
+
+// parseThrottling returns the number of periods and throttled periods from
+// the content of a cgroup v1 or v2 cpu.stat file.
+func parseThrottling(cpuStat string) (periods uint64, throttled uint64) {
+	scanner := bufio.NewScanner(strings.NewReader(cpuStat))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "nr_periods":
+			periods = value
+		case "nr_throttled":
+			throttled = value
+		}
+	}
+	return
+}
+
+// cgroupThrottling returns the throttling counters of the cgroup of the
+// current process, zero when unknown.
+func cgroupThrottling() (periods uint64, throttled uint64) {
+	dir, _ := cgroupCPUDir()
+	if dir == "" {
+		return 0, 0
+	}
+	return parseThrottling(readTrimmed(path.Join(dir, "cpu.stat")))
+}
+
+// turboEnabled reports whether turbo boost is enabled, through either the
+// intel_pstate or the cpufreq boost knob.
+func turboEnabled() bool {
+	if noTurbo := readTrimmed("/sys/devices/system/cpu/intel_pstate/no_turbo"); noTurbo != "" {
+		return noTurbo == "0"
+	}
+	return readTrimmed("/sys/devices/system/cpu/cpufreq/boost") == "1"
+}
+
+// readHostState reads the host conditions from /proc and /sys. Conditions
+// which cannot be read on the current platform are left empty.
+func readHostState() hostState {
+	meminfo := readTrimmed("/proc/meminfo")
+	h := hostState{
+		LoadAvg:      parseLoadAvg(readTrimmed("/proc/loadavg")),
+		CPUs:         runtime.NumCPU(),
+		Governors:    map[string]int{},
+		Turbo:        turboEnabled(),
+		CPUQuota:     cgroupCPUQuota(),
+		MemTotal:     parseMeminfo(meminfo, "MemTotal"),
+		MemAvailable: parseMeminfo(meminfo, "MemAvailable"),
+	}
+	_, h.Throttled = cgroupThrottling()
+	governors, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_governor")
+	for _, name := range governors {
+		if governor := readTrimmed(name); governor != "" {
+			h.Governors[governor]++
+		}
+	}
+	return h
+}
+
+// warnings returns the host conditions making benchmark results noisy.
+func (h hostState) warnings() (warnings []string) {
+	if h.LoadAvg > math.Max(1, 0.1*float64(h.CPUs)) {
+		warnings = append(warnings, fmt.Sprintf("the load average is %.2f on %d CPUs, other processes compete with the benchmarks", h.LoadAvg, h.CPUs))
+	}
+	governors := make([]string, 0, len(h.Governors))
+	for governor := range h.Governors {
+		if governor != "performance" {
+			governors = append(governors, governor)
+		}
+	}
+	sort.Strings(governors)
+	for _, governor := range governors {
+		warnings = append(warnings, fmt.Sprintf("the cpufreq governor of %d CPUs is %s, not performance", h.Governors[governor], governor))
+	}
+	if h.Turbo {
+		warnings = append(warnings, "turbo boost is enabled, the CPU frequency depends on its temperature")
+	}
+	if h.CPUQuota > 0 && h.Throttled > 0 {
+		warnings = append(warnings, fmt.Sprintf("the cgroup CPU quota of %g CPUs throttled %d periods", h.CPUQuota, h.Throttled))
+	}
+	if h.MemTotal > 0 && h.MemAvailable < h.MemTotal/10 {
+		warnings = append(warnings, fmt.Sprintf("only %d MiB of %d MiB of memory are available", h.MemAvailable>>20, h.MemTotal>>20))
+	}
+	return
+}
+
+// noiseSampler samples the host noise while a benchmark runs.
+type noiseSampler struct {
+	cpu                cpuTimes
+	periods, throttled uint64
+	stop               chan struct{}
+	done               sync.WaitGroup
+	maxLoadAvg         float64
+}
+
+// startNoiseSampler starts sampling the host noise, until finish is called.
+func startNoiseSampler() *noiseSampler {
+	s := &noiseSampler{
+		cpu:        parseCPUTimes(readTrimmed("/proc/stat"), isolatedCPUs()),
+		stop:       make(chan struct{}),
+		maxLoadAvg: parseLoadAvg(readTrimmed("/proc/loadavg")),
+	}
+	s.periods, s.throttled = cgroupThrottling()
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		ticker := time.NewTicker(noiseSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.maxLoadAvg = math.Max(s.maxLoadAvg, parseLoadAvg(readTrimmed("/proc/loadavg")))
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// finish stops sampling and returns the noise of the run, given the CPU time
+// used by the benchmark itself.
+func (s *noiseSampler) finish(benchmarkCPU time.Duration) NoiseSample {
+	close(s.stop)
+	s.done.Wait()
+	cpu := parseCPUTimes(readTrimmed("/proc/stat"), isolatedCPUs())
+	periods, throttled := cgroupThrottling()
+	return noiseSample(s.maxLoadAvg, s.cpu, cpu, periods-s.periods, throttled-s.throttled, benchmarkCPU)
+}
+
+// noiseSample computes the noise of a run from the CPU times at its start and
+// end, the cgroup throttling counters over the run, and the CPU time used by
+// the benchmark.
+func noiseSample(loadAvg float64, start cpuTimes, end cpuTimes, periods uint64, throttled uint64, benchmarkCPU time.Duration) (sample NoiseSample) {
+	sample.LoadAvg = loadAvg
+	if total := float64(end.Total - start.Total); end.Total > start.Total {
+		busy := float64(end.Busy-start.Busy) - benchmarkCPU.Seconds()*userHZ
+		sample.ForeignCPU = math.Max(0, busy) / total
+		sample.Steal = float64(end.Steal-start.Steal) / total
+	}
+	if periods > 0 {
+		sample.Throttled = float64(throttled) / float64(periods)
+	}
+	sample.Score = sample.ForeignCPU + sample.Steal + sample.Throttled
+	return
+}
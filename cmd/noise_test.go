@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseLoadAvg(t *testing.T) {
+	tests := []struct {
+		loadavg string
+		want    float64
+	}{
+		{"0.52 0.58 0.59 1/1170 12345", 0.52},
+		{"", 0},
+		{"invalid", 0},
+	}
+	for _, tt := range tests {
+		if got := parseLoadAvg(tt.loadavg); got != tt.want {
+			t.Errorf("parseLoadAvg(%q) = %g, want %g", tt.loadavg, got, tt.want)
+		}
+	}
+}
+
+func Test_parseCPUTimes(t *testing.T) {
+	stat := "cpu  100 10 50 1000 20 5 5 10 0 0\ncpu0 50 5 25 500 10 2 3 5 0 0\ncpu1 50 5 25 500 10 3 2 5 0 0\nintr 123\n"
+	tests := []struct {
+		name string
+		stat string
+		cpus []int
+		want cpuTimes
+	}{
+		{"all", stat, nil, cpuTimes{Busy: 170, Steal: 10, Total: 1200}},
+		{"cpuset", stat, []int{1}, cpuTimes{Busy: 85, Steal: 5, Total: 600}},
+		{"empty", "", nil, cpuTimes{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseCPUTimes(tt.stat, tt.cpus); got != tt.want {
+				t.Errorf("parseCPUTimes() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseThrottling(t *testing.T) {
+	tests := []struct {
+		name          string
+		cpuStat       string
+		wantPeriods   uint64
+		wantThrottled uint64
+	}{
+		{"v2", "usage_usec 123\nnr_periods 40\nnr_throttled 4\nthrottled_usec 1000\n", 40, 4},
+		{"v1", "nr_periods 10\nnr_throttled 0\nthrottled_time 0\n", 10, 0},
+		{"empty", "", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			periods, throttled := parseThrottling(tt.cpuStat)
+			if periods != tt.wantPeriods || throttled != tt.wantThrottled {
+				t.Errorf("parseThrottling() = %d, %d, want %d, %d", periods, throttled, tt.wantPeriods, tt.wantThrottled)
+			}
+		})
+	}
+}
+
+func Test_hostState_warnings(t *testing.T) {
+	quiet := hostState{LoadAvg: 0.2, CPUs: 8, Governors: map[string]int{"performance": 8}, MemTotal: 16 << 30, MemAvailable: 8 << 30}
+	if got := quiet.warnings(); len(got) != 0 {
+		t.Errorf("warnings() = %q, want none", got)
+	}
+	noisy := hostState{
+		LoadAvg:      4,
+		CPUs:         8,
+		Governors:    map[string]int{"performance": 2, "powersave": 4, "ondemand": 2},
+		Turbo:        true,
+		CPUQuota:     2,
+		Throttled:    12,
+		MemTotal:     16 << 30,
+		MemAvailable: 1 << 30,
+	}
+	want := []string{
+		"the load average is 4.00 on 8 CPUs, other processes compete with the benchmarks",
+		"the cpufreq governor of 2 CPUs is ondemand, not performance",
+		"the cpufreq governor of 4 CPUs is powersave, not performance",
+		"turbo boost is enabled, the CPU frequency depends on its temperature",
+		"the cgroup CPU quota of 2 CPUs throttled 12 periods",
+		"only 1024 MiB of 16384 MiB of memory are available",
+	}
+	if got := noisy.warnings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("warnings() = %q, want %q", got, want)
+	}
+}
+
+func Test_noiseSample(t *testing.T) {
+	start := cpuTimes{Busy: 1000, Steal: 0, Total: 10000}
+	// 4 CPUs over 2s: 800 ticks, of which 300 busy, 100 by the benchmark.
+	end := cpuTimes{Busy: 1300, Steal: 40, Total: 10800}
+	got := noiseSample(0.5, start, end, 20, 2, time.Second)
+	want := NoiseSample{LoadAvg: 0.5, ForeignCPU: 0.25, Steal: 0.05, Throttled: 0.1, Score: 0.4}
+	if got.LoadAvg != want.LoadAvg || got.ForeignCPU != want.ForeignCPU || got.Steal != want.Steal || got.Throttled != want.Throttled || got.Score-want.Score > 1e-9 || want.Score-got.Score > 1e-9 {
+		t.Errorf("noiseSample() = %+v, want %+v", got, want)
+	}
+	if got := noiseSample(0, start, start, 0, 0, 0); got != (NoiseSample{}) {
+		t.Errorf("noiseSample() = %+v, want zero when no time elapsed", got)
+	}
+}
@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// outputDir is set by the --output-dir flag.
+var outputDir string
+
+// output is the run directory of the test command, nil for other commands.
+var output *runOutput
+
+// manifestFile is the name of the manifest written to every run directory.
+const manifestFile = "manifest.json"
+
+// Artifact describes a file written to the run directory.
+type Artifact struct {
+	// Path is the slash separated file path, relative to the run directory.
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestBenchmark lists the commands run for a benchmark, the files written
+// for it and its outcome.
+type ManifestBenchmark struct {
+	Benchmark Benchmark `json:"benchmark"`
+	// Status is passed, failed or skipped, with Error telling why for the
+	// latter two.
+	Status   string  `json:"status"`
+	Duration float64 `json:"durationSeconds"`
+	Error    string  `json:"error,omitempty"`
+	// CachedFrom is the run directory, relative to the output directory,
+	// holding the artifacts of a benchmark skipped by --resume.
+	CachedFrom string     `json:"cachedFrom,omitempty"`
+	Commands   []string   `json:"commands"`
+	Artifacts  []Artifact `json:"artifacts"`
+}
+
+// Manifest describes the content of a run directory.
+type Manifest struct {
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Args      []string  `json:"args"`
+	GitOrg    string    `json:"gitOrg"`
+	GitRepo   string    `json:"gitRepo"`
+	GitBranch string    `json:"gitBranch"`
+	GitCommit string    `json:"gitCommit"`
+	// Environment is the machine and toolchain of the run.
+	Environment *Fingerprint `json:"environment,omitempty"`
+	// Isolation is the CPU affinity and scheduling of the benchmarks.
+	Isolation *Isolation `json:"isolation,omitempty"`
+	// Warnings lists the host conditions found by the pre-flight checks to
+	// make benchmark results noisy.
+	Warnings   []string            `json:"warnings,omitempty"`
+	Benchmarks []ManifestBenchmark `json:"benchmarks"`
+	// Artifacts lists the files not specific to a benchmark, e.g. the log.
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// runOutput is the directory holding every file written by a test run.
+type runOutput struct {
+	// Dir is the absolute path of the run directory.
+	Dir      string
+	manifest Manifest
+	files    []string
+}
+
+// newRunOutput creates a new run directory under base, named after the start
+// time and commit, and copies the log to it.
+func newRunOutput(base string, args []string) (*runOutput, error) {
+	start := time.Now().UTC()
+	name := start.Format("20060102T150405Z")
+	if gitCommit != "" {
+		name += "-" + gitCommit
+	}
+	dir, err := filepath.Abs(filepath.Join(base, name))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return nil, err
+	}
+	// Runs started in the same second get a suffix rather than sharing a
+	// directory.
+	for i := 2; ; i++ {
+		if err = os.Mkdir(dir, 0755); !os.IsExist(err) {
+			break
+		}
+		dir = filepath.Join(filepath.Dir(dir), fmt.Sprintf("%s-%d", name, i))
+	}
+	if err != nil {
+		return nil, err
+	}
+	o := &runOutput{
+		Dir: dir,
+		manifest: Manifest{
+			StartedAt: start,
+			Args:      args,
+			GitOrg:    gitOrg,
+			GitRepo:   gitRepo,
+			GitBranch: gitBranch,
+			GitCommit: gitCommit,
+		},
+	}
+	logFile, err := os.Create(o.path("codeperf.log"))
+	if err != nil {
+		return nil, err
+	}
+	log.SetOutput(io.MultiWriter(os.Stderr, logFile))
+	o.files = append(o.files, logFile.Name())
+	log.Printf("Writing the run output to %s.", dir)
+	return o, nil
+}
+
+// path returns the absolute path of the given file of the run directory.
+func (o *runOutput) path(elem ...string) string {
+	return filepath.Join(append([]string{o.Dir}, elem...)...)
+}
+
+// benchmarkDir returns the directory holding the files of the benchmark,
+// creating it if needed. It mirrors the package directory relative to the
+// module, so that benchmarks of the same name in different packages do not
+// share it.
+func (o *runOutput) benchmarkDir(b Benchmark) (string, error) {
+	pkg := filepath.FromSlash(Module{Dir: b.ModuleDir}.relDir(b.Dir))
+	dir := o.path("benchmarks", pkg, b.FileName())
+	if b.ModuleDir != "." {
+		dir = o.path("modules", fileNameReplacer.Replace(b.Module), "benchmarks", pkg, b.FileName())
+	}
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// addFile records a file not specific to a benchmark in the manifest.
+func (o *runOutput) addFile(name string) {
+	o.files = append(o.files, name)
+}
+
+// addBenchmark records the outcome of the benchmark, the commands run for it
+// and every file of its directory in the manifest, then rewrites the manifest
+// so that it stays valid if the run is interrupted. It returns the recorded
+// entry.
+func (o *runOutput) addBenchmark(outcome benchmarkOutcome, commands []string) (entry ManifestBenchmark, err error) {
+	entry = ManifestBenchmark{
+		Benchmark: outcome.Benchmark,
+		Status:    outcome.Status,
+		Duration:  outcome.Duration.Seconds(),
+		Commands:  commands,
+	}
+	if outcome.Err != nil {
+		entry.Error = outcome.Err.Error()
+	}
+	if outcome.Cached != nil {
+		// The artifacts were written by a previous run.
+		entry.CachedFrom = outcome.Cached.RunDir
+		entry.Artifacts = outcome.Cached.Artifacts
+		o.manifest.Benchmarks = append(o.manifest.Benchmarks, entry)
+		return entry, o.writeManifest()
+	}
+	dir, err := o.benchmarkDir(outcome.Benchmark)
+	if err != nil {
+		return
+	}
+	var files []string
+	err = filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, name)
+		}
+		return err
+	})
+	if err != nil {
+		return
+	}
+	if entry.Artifacts, err = o.artifacts(files); err != nil {
+		return
+	}
+	o.manifest.Benchmarks = append(o.manifest.Benchmarks, entry)
+	return entry, o.writeManifest()
+}
+
+func (o *runOutput) artifacts(files []string) (artifacts []Artifact, err error) {
+	sort.Strings(files)
+	for _, name := range files {
+		artifact, err := o.artifact(name)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return
+}
+
+func (o *runOutput) artifact(name string) (artifact Artifact, err error) {
+	return fileArtifact(o.Dir, name)
+}
+
+// fileArtifact describes the file name, with a path relative to root.
+func fileArtifact(root string, name string) (artifact Artifact, err error) {
+	rel, err := filepath.Rel(root, name)
+	if err != nil {
+		return
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return
+	}
+	return Artifact{Path: filepath.ToSlash(rel), Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// verifyArtifact checks that the artifact of the given root directory still
+// exists with the same content.
+func verifyArtifact(root string, artifact Artifact) error {
+	got, err := fileArtifact(root, filepath.Join(root, filepath.FromSlash(artifact.Path)))
+	if err != nil {
+		return err
+	}
+	if got != artifact {
+		return fmt.Errorf("%s changed since it was written", artifact.Path)
+	}
+	return nil
+}
+
+// writeManifest writes the manifest of the files recorded so far.
+func (o *runOutput) writeManifest() (err error) {
+	o.manifest.UpdatedAt = time.Now().UTC()
+	if o.manifest.Artifacts, err = o.artifacts(o.files); err != nil {
+		return
+	}
+	content, err := json.MarshalIndent(o.manifest, "", "  ")
+	if err != nil {
+		return
+	}
+	return os.WriteFile(o.path(manifestFile), append(content, '\n'), 0644)
+}
+
+// writeJSONFile writes v as indented JSON to name, creating its directory.
+func writeJSONFile(name string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(content, '\n'), 0644)
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_runOutput(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+	base := t.TempDir()
+	first, err := newRunOutput(base, []string{"test", "./..."})
+	if err != nil {
+		t.Fatalf("newRunOutput() error = %v", err)
+	}
+	second, err := newRunOutput(base, nil)
+	if err != nil {
+		t.Fatalf("newRunOutput() error = %v", err)
+	}
+	if first.Dir == second.Dir || filepath.Dir(first.Dir) != base {
+		t.Errorf("newRunOutput() dirs = %s, %s, want distinct directories under %s", first.Dir, second.Dir, base)
+	}
+
+	for _, b := range []Benchmark{
+		{Name: "BenchmarkGet", Dir: "a", ModuleDir: "."},
+		{Name: "BenchmarkGet", Dir: "b/c", ModuleDir: "."},
+		{Name: "BenchmarkGet", Dir: ".", ModuleDir: "."},
+	} {
+		dir, err := first.benchmarkDir(b)
+		if want := first.path("benchmarks", filepath.FromSlash(b.Dir), "BenchmarkGet"); err != nil || dir != want {
+			t.Errorf("benchmarkDir(%s) = %s, %v, want %s", b.Dir, dir, err, want)
+		}
+	}
+	nested := Benchmark{Name: "BenchmarkNested", Sub: "a/b", Dir: "nested/store", Module: "example.com/nested", ModuleDir: "nested"}
+	dir, err := first.benchmarkDir(nested)
+	if err != nil {
+		t.Fatalf("benchmarkDir() error = %v", err)
+	}
+	if want := first.path("modules", "example.com_nested", "benchmarks", "store", "BenchmarkNested_a_b"); dir != want {
+		t.Errorf("benchmarkDir() = %s, want %s", dir, want)
+	}
+	writeFiles(t, dir, map[string]string{"cpuprofile.out": "cpu", "reports/cpu/lines.json": "{}"})
+	if _, err := first.addBenchmark(benchmarkOutcome{Benchmark: nested, Status: statusPassed}, []string{"store.test -test.run=^$"}); err != nil {
+		t.Fatalf("addBenchmark() error = %v", err)
+	}
+
+	content, err := os.ReadFile(first.path(manifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if len(manifest.Benchmarks) != 1 || len(manifest.Benchmarks[0].Artifacts) != 2 {
+		t.Fatalf("manifest = %s", content)
+	}
+	cpu := manifest.Benchmarks[0].Artifacts[0]
+	if cpu.Path != "modules/example.com_nested/benchmarks/store/BenchmarkNested_a_b/cpuprofile.out" || cpu.Size != 3 ||
+		cpu.SHA256 != "68ab84f7c6d0f5781585eb1b5289499fb29081b918f71ebddb5f72021c9ef9c5" {
+		t.Errorf("artifact = %+v", cpu)
+	}
+	if len(manifest.Artifacts) != 1 || !strings.HasSuffix(manifest.Artifacts[0].Path, "codeperf.log") {
+		t.Errorf("run artifacts = %+v, want the log", manifest.Artifacts)
+	}
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// stopGracePeriod is how long a stopped command may take to exit after being
+// interrupted before its process group is killed.
+const stopGracePeriod = 5 * time.Second
+
+// command is an external command run by codeperf, e.g. a test binary.
+type command struct {
+	Name string
+	Args []string
+	// Dir is the working directory of the command, the current one if empty.
+	Dir string
+	// Env is the environment of the command, that of codeperf if nil.
+	Env []string
+	// Stream copies the output of the command to stderr as it is produced.
+	Stream bool
+	// Isolation, if set, is applied to the command.
+	Isolation *Isolation
+	// CPUTime, if set, receives the user and system CPU time of the command
+	// once it exited.
+	CPUTime *time.Duration
+}
+
+// String returns the command line, quoting arguments where needed so that it
+// can be pasted into a shell.
+func (c command) String() string {
+	words := []string{quoteArg(c.Name)}
+	for _, arg := range c.Args {
+		words = append(words, quoteArg(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+func quoteArg(arg string) string {
+	if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`*?[]|&;<>()#~!{}") {
+		return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return arg
+}
+
+// runningCommands holds the commands currently running, for signals to be
+// forwarded to them.
+var runningCommands = struct {
+	sync.Mutex
+	cmds map[*exec.Cmd]bool
+}{cmds: map[*exec.Cmd]bool{}}
+
+// run runs the command in its own process group and returns its combined
+// output. When ctx is done the command is interrupted, then killed along
+// with its children if it did not exit within stopGracePeriod.
+func (c command) run(ctx context.Context) ([]byte, error) {
+	var out bytes.Buffer
+	var w io.Writer = &out
+	if c.Stream {
+		w = io.MultiWriter(&out, os.Stderr)
+	}
+	cmd := exec.Command(c.Name, c.Args...)
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	cmd.Stdout = w
+	cmd.Stderr = w
+	setProcessGroup(cmd)
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(ctx)
+	}
+	start := cmd.Start
+	if c.Isolation != nil {
+		start = func() error { return c.Isolation.start(cmd) }
+	}
+	if err := start(); err != nil {
+		return nil, err
+	}
+	runningCommands.Lock()
+	runningCommands.cmds[cmd] = true
+	runningCommands.Unlock()
+	defer func() {
+		runningCommands.Lock()
+		delete(runningCommands.cmds, cmd)
+		runningCommands.Unlock()
+	}()
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if c.CPUTime != nil && cmd.ProcessState != nil {
+			*c.CPUTime = cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+		}
+		return out.Bytes(), err
+	case <-ctx.Done():
+	}
+	signalProcessGroup(cmd, os.Interrupt)
+	select {
+	case <-done:
+	case <-time.After(stopGracePeriod):
+		signalProcessGroup(cmd, os.Kill)
+		<-done
+	}
+	return out.Bytes(), contextError(ctx)
+}
+
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errors.New("timed out")
+	}
+	return errors.New("interrupted")
+}
+
+// signalContext returns a context canceled on SIGINT or SIGTERM. The signal
+// is forwarded to the process group of every running command, as they don't
+// share the terminal process group of codeperf. A second signal kills the
+// running commands and exits right away, e.g. when a benchmark ignores the
+// first one.
+func signalContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 1)
+	stopped := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Printf("Received %v, stopping the running commands. Send it again to exit right away.", sig)
+			signalRunningCommands(sig)
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case sig := <-signals:
+			log.Printf("Received %v again, killing the running commands and exiting.", sig)
+			signalRunningCommands(os.Kill)
+			os.Exit(1)
+		case <-stopped:
+		}
+	}()
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(stopped)
+		})
+		cancel()
+	}
+}
+
+// signalRunningCommands sends sig to the process group of every running
+// command.
+func signalRunningCommands(sig os.Signal) {
+	runningCommands.Lock()
+	defer runningCommands.Unlock()
+	for cmd := range runningCommands.cmds {
+		signalProcessGroup(cmd, sig)
+	}
+}
+
+// withOptionalTimeout returns a context done after timeout, or never if
+// timeout is zero.
+func withOptionalTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+// commandError describes the failure of a command along with its output,
+// which is otherwise lost once codeperf exits.
+func commandError(c command, err error, out []byte) error {
+	return fmt.Errorf("%s failed: %v\n%s", c, err, string(out))
+}
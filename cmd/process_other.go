@@ -0,0 +1,19 @@
+//go:build !unix
+
+package cmd
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op: process groups are only supported on unix.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcessGroup sends sig to the started command. Signals other than
+// kill are not supported everywhere, so the command is killed on failure.
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) {
+	if err := cmd.Process.Signal(sig); err != nil {
+		cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_command_String(t *testing.T) {
+	tests := []struct {
+		name string
+		c    command
+		want string
+	}{
+		{"plain", command{Name: "/tmp/store.test", Args: []string{"-test.run=^$", "-test.benchtime=1x"}}, `/tmp/store.test '-test.run=^$' -test.benchtime=1x`},
+		{"spaces", command{Name: "/tmp/my dir/store.test", Args: []string{"-test.cpuprofile", "/tmp/my dir/cpu.out"}}, `'/tmp/my dir/store.test' -test.cpuprofile '/tmp/my dir/cpu.out'`},
+		{"quotes", command{Name: "go", Args: []string{"-test.bench=^BenchmarkA$/^it's$", ""}}, `go '-test.bench=^BenchmarkA$/^it'\''s$' ''`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.c.String(); got != tt.want {
+				t.Errorf("command.String() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_command_run(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	out, err := command{Name: "sh", Args: []string{"-c", `printf '%s|' "$@"; echo failed >&2; exit 3`, "sh", "a b", "c"}}.run(context.Background())
+	if err == nil || !strings.Contains(string(out), "a b|c|") || !strings.Contains(string(out), "failed") {
+		t.Errorf("command.run() = %q, %v, want the arguments, stderr and an exit error", out, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	// The child sleep shares the process group and must be stopped too.
+	_, err = command{Name: "sh", Args: []string{"-c", "sleep 30; echo done"}}.run(ctx)
+	if err == nil || err.Error() != "timed out" {
+		t.Errorf("command.run() error = %v, want timed out", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("command.run() took %s to stop", elapsed)
+	}
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import "fmt"
+
+// cpus is set by the --cpu flag.
+var cpus []int
+
+// validateProcs checks the GOMAXPROCS values given by --cpu.
+func validateProcs(values []int) error {
+	for _, procs := range values {
+		if procs < 1 {
+			return fmt.Errorf("GOMAXPROCS values must be at least 1, got %d", procs)
+		}
+	}
+	return nil
+}
+
+// expandProcs replaces every benchmark by one entry per GOMAXPROCS value, so
+// that each value gets its own results and profiles. Benchmarks are kept as
+// is when no value is given.
+func expandProcs(benchmarks []Benchmark, values []int) []Benchmark {
+	if len(values) == 0 {
+		return benchmarks
+	}
+	expanded := make([]Benchmark, 0, len(benchmarks)*len(values))
+	for _, benchmark := range benchmarks {
+		seen := map[int]bool{}
+		for _, procs := range values {
+			if seen[procs] {
+				continue
+			}
+			seen[procs] = true
+			benchmark.Procs = procs
+			expanded = append(expanded, benchmark)
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_expandProcs(t *testing.T) {
+	get := Benchmark{Name: "BenchmarkGet", Dir: "store"}
+	table := Benchmark{Name: "BenchmarkTable", Sub: "size=10", Dir: "store"}
+	tests := []struct {
+		name   string
+		values []int
+		want   []string
+	}{
+		{"none", nil, []string{"BenchmarkGet", "BenchmarkTable/size=10"}},
+		{"sweep", []int{1, 4}, []string{"BenchmarkGet-1", "BenchmarkGet-4", "BenchmarkTable/size=10-1", "BenchmarkTable/size=10-4"}},
+		{"duplicates", []int{2, 2}, []string{"BenchmarkGet-2", "BenchmarkTable/size=10-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, b := range expandProcs([]Benchmark{get, table}, tt.values) {
+				got = append(got, b.RunName())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandProcs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := expandProcs([]Benchmark{table}, []int{8})[0].FileName(); got != "BenchmarkTable_size=10-8" {
+		t.Errorf("FileName() = %s, want BenchmarkTable_size=10-8", got)
+	}
+}
+
+func Test_validateProcs(t *testing.T) {
+	if err := validateProcs([]int{1, 2, 4, 8}); err != nil {
+		t.Errorf("validateProcs() error = %v", err)
+	}
+	if err := validateProcs([]int{4, 0}); err == nil {
+		t.Errorf("validateProcs() expected an error for 0")
+	}
+}
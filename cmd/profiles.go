@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// profileKind describes a profile type that can be collected while running
+// a benchmark.
+type profileKind struct {
+	// Name identifies the profile in the --profiles flag, the profiles
+	// directive and the upload path.
+	Name string
+	// Flag is the go test flag writing the profile, without the dash nor the
+	// test. prefix used when running the test binary.
+	Flag string
+	// SampleTypes lists the sample types exported separately. Empty means
+	// only the default sample type is exported.
+	SampleTypes []string
+	// RateFlag is the go test flag controlling the profile sampling rate, if
+	// any, in the same form as Flag. It is set to the value of Rate.
+	RateFlag string
+	Rate     *int
+}
+
+var cpuProfileKind = profileKind{Name: "cpu", Flag: "cpuprofile"}
+
+var memProfileKind = profileKind{
+	Name:        "mem",
+	Flag:        "memprofile",
+	SampleTypes: []string{"alloc_space", "alloc_objects", "inuse_space", "inuse_objects"},
+}
+
+// blockProfileRate and mutexProfileFraction are set by the
+// --blockprofilerate and --mutexprofilefraction flags.
+var blockProfileRate int
+var mutexProfileFraction int
+
+var blockProfileKind = profileKind{
+	Name:        "block",
+	Flag:        "blockprofile",
+	SampleTypes: []string{"contentions", "delay"},
+	RateFlag:    "blockprofilerate",
+	Rate:        &blockProfileRate,
+}
+
+var mutexProfileKind = profileKind{
+	Name:        "mutex",
+	Flag:        "mutexprofile",
+	SampleTypes: []string{"contentions", "delay"},
+	RateFlag:    "mutexprofilefraction",
+	Rate:        &mutexProfileFraction,
+}
+
+// profileKinds lists the supported profile types, in collection order.
+var profileKinds = []profileKind{cpuProfileKind, memProfileKind, blockProfileKind, mutexProfileKind}
+
+// exportPath returns the upload path segment of the given sample type.
+// CPU profiles are uploaded under cpu, the other profiles under
+// <name>/<sample type>.
+func (k profileKind) exportPath(sampleType string) string {
+	if sampleType == "" {
+		return k.Name
+	}
+	return k.Name + "/" + sampleType
+}
+
+// exportedSampleTypes returns the sample types to export, "" standing for the
+// default sample type.
+func (k profileKind) exportedSampleTypes() []string {
+	if len(k.SampleTypes) == 0 {
+		return []string{""}
+	}
+	return k.SampleTypes
+}
+
+// runArgs returns the test binary flags writing the profile to the given
+// file, at the sampling rate set for it if any.
+func (k profileKind) runArgs(name string) []string {
+	args := []string{"-test." + k.Flag, name}
+	if k.RateFlag != "" {
+		args = append(args, fmt.Sprintf("-test.%s=%d", k.RateFlag, *k.Rate))
+	}
+	return args
+}
+
+// fileName returns the name of the file the profile of the benchmark is
+// written to.
+func (k profileKind) fileName(b Benchmark) string {
+	return fmt.Sprintf("%s-%s.out", k.Flag, b.FileName())
+}
+
+func profileNames() (names []string) {
+	for _, k := range profileKinds {
+		names = append(names, k.Name)
+	}
+	return
+}
+
+// parseProfileKinds resolves a list of profile names.
+func parseProfileKinds(names []string) (kinds []profileKind, err error) {
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, k := range profileKinds {
+			if k.Name == name {
+				kinds = append(kinds, k)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown profile %q, expected one of %s", name, strings.Join(profileNames(), ","))
+		}
+	}
+	return
+}
+
+// benchmarkProfiles returns the profiles to collect for the benchmark: those
+// of its profiles directive if set, the ones given by --profiles otherwise.
+func benchmarkProfiles(b Benchmark) []profileKind {
+	names := profiles
+	if len(b.Directives.Profiles) > 0 {
+		names = b.Directives.Profiles
+	}
+	// Names are validated by the directive parser and on startup.
+	kinds, _ := parseProfileKinds(names)
+	return kinds
+}
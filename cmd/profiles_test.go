@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseProfileKinds(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		want    []string
+		wantErr bool
+	}{
+		{"none", nil, nil, false},
+		{"all", []string{"cpu", "mem", "block", "mutex"}, []string{"cpu", "mem", "block", "mutex"}, false},
+		{"trimmed", []string{" mem", "cpu "}, []string{"mem", "cpu"}, false},
+		{"unknown", []string{"cpu", "heap"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kinds, err := parseProfileKinds(tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProfileKinds() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, k := range kinds {
+				got = append(got, k.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProfileKinds() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_profileKind_exportPath(t *testing.T) {
+	tests := []struct {
+		kind       profileKind
+		sampleType string
+		want       string
+	}{
+		{cpuProfileKind, "", "cpu"},
+		{memProfileKind, "alloc_space", "mem/alloc_space"},
+		{memProfileKind, "inuse_objects", "mem/inuse_objects"},
+		{blockProfileKind, "contentions", "block/contentions"},
+		{blockProfileKind, "delay", "block/delay"},
+		{mutexProfileKind, "contentions", "mutex/contentions"},
+		{mutexProfileKind, "delay", "mutex/delay"},
+	}
+	for _, tt := range tests {
+		if got := tt.kind.exportPath(tt.sampleType); got != tt.want {
+			t.Errorf("%s.exportPath(%q) = %q, want %q", tt.kind.Name, tt.sampleType, got, tt.want)
+		}
+	}
+}
+
+func Test_profileKind_exportedSampleTypes(t *testing.T) {
+	tests := []struct {
+		kind profileKind
+		want []string
+	}{
+		{cpuProfileKind, []string{""}},
+		{memProfileKind, []string{"alloc_space", "alloc_objects", "inuse_space", "inuse_objects"}},
+		{blockProfileKind, []string{"contentions", "delay"}},
+		{mutexProfileKind, []string{"contentions", "delay"}},
+	}
+	for _, tt := range tests {
+		if got := tt.kind.exportedSampleTypes(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s.exportedSampleTypes() = %q, want %q", tt.kind.Name, got, tt.want)
+		}
+	}
+}
+
+func Test_profileKind_runArgs(t *testing.T) {
+	defer func(rate, fraction int) { blockProfileRate, mutexProfileFraction = rate, fraction }(blockProfileRate, mutexProfileFraction)
+	blockProfileRate, mutexProfileFraction = 100, 5
+	tests := []struct {
+		kind profileKind
+		want []string
+	}{
+		{cpuProfileKind, []string{"-test.cpuprofile", "p.out"}},
+		{memProfileKind, []string{"-test.memprofile", "p.out"}},
+		{blockProfileKind, []string{"-test.blockprofile", "p.out", "-test.blockprofilerate=100"}},
+		{mutexProfileKind, []string{"-test.mutexprofile", "p.out", "-test.mutexprofilefraction=5"}},
+	}
+	for _, tt := range tests {
+		if got := tt.kind.runArgs("p.out"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s.runArgs() = %q, want %q", tt.kind.Name, got, tt.want)
+		}
+	}
+}
@@ -1,54 +1,374 @@
 package cmd
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"go/ast"
+	"go/build"
+	"go/format"
 	"go/parser"
+	"go/scanner"
 	"go/token"
 	"io/fs"
-	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-func funcNames(filename string, exportOnly bool) []string {
+// Benchmark describes a single benchmark function discovered in the project
+// together with the package it belongs to.
+type Benchmark struct {
+	// Name is the benchmark function name, e.g. BenchmarkGet.
+	Name string `json:"name"`
+	// Dir is the slash separated package directory, relative to the project root.
+	Dir string `json:"dir"`
+	// ImportPath is the package import path. When the package belongs to no
+	// module it falls back to the relative package pattern.
+	ImportPath string `json:"importPath"`
+	// Module is the path of the module the package belongs to, empty if none.
+	Module string `json:"module,omitempty"`
+	// ModuleDir is the slash separated module root, relative to the project
+	// root. go test is run from there.
+	ModuleDir string `json:"moduleDir"`
+	// File is the slash separated test file, relative to the project root.
+	File string `json:"file"`
+	// Line is the line of the benchmark function declaration.
+	Line int `json:"line"`
+	// EndLine is the line of the closing brace of the benchmark function.
+	EndLine int `json:"endLine"`
+	// Doc is the benchmark doc comment, without comment markers and directives.
+	Doc string `json:"doc,omitempty"`
+	// BodyHash is the hex encoded SHA-256 of the gofmt-ed function body,
+	// comments excluded, so that changes to the benchmark code itself can be
+	// told apart between commits.
+	BodyHash string `json:"bodyHash"`
+	// Sub is the sub-benchmark path created via b.Run, as reported by go test.
+	// Empty for top-level benchmarks.
+	Sub string `json:"sub,omitempty"`
+	// Procs is the GOMAXPROCS value the benchmark runs with, as set by --cpu.
+	// Zero runs it with the go test default.
+	Procs int `json:"procs,omitempty"`
+	// Directives holds the //codeperf: settings of the benchmark function.
+	Directives Directives `json:"directives"`
+}
+
+// FullName returns the benchmark name as reported by go test, i.e. Parent/Child
+// for sub-benchmarks.
+func (b Benchmark) FullName() string {
+	if b.Sub == "" {
+		return b.Name
+	}
+	return b.Name + "/" + b.Sub
+}
+
+// RunName returns the full name with the GOMAXPROCS suffix, as reported by
+// go test -cpu, e.g. BenchmarkGet-4. It identifies the benchmark uploads.
+func (b Benchmark) RunName() string {
+	if b.Procs == 0 {
+		return b.FullName()
+	}
+	return fmt.Sprintf("%s-%d", b.FullName(), b.Procs)
+}
+
+// FileName returns a variant of the run name that is safe to use in file names.
+func (b Benchmark) FileName() string {
+	return fileNameReplacer.Replace(b.RunName())
+}
+
+var fileNameReplacer = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_", " ", "_")
+
+// Package returns the package pattern relative to the project root.
+func (b Benchmark) Package() string {
+	return relPackage(b.Dir)
+}
+
+// ModulePackage returns the package pattern relative to the module root, to
+// hand over to go test run from ModuleDir.
+func (b Benchmark) ModulePackage() string {
+	return relPackage(Module{Dir: b.ModuleDir}.relDir(b.Dir))
+}
+
+// Exclusion records a Benchmark-prefixed function that was not selected
+// for profiling, and why.
+type Exclusion struct {
+	Name   string `json:"name"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// Discovery holds the outcome of walking a project for benchmarks.
+type Discovery struct {
+	Benchmarks  []Benchmark
+	Excluded    []Exclusion
+	Diagnostics []Diagnostic
+	Modules     []Module
+	// DirectiveErrors lists the malformed or unknown //codeperf: directives
+	// attached to benchmarks.
+	DirectiveErrors []Diagnostic
+}
+
+// Diagnostic describes a file or directory that could not be read or parsed
+// during discovery. Line and Column are zero when the position is unknown.
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	switch {
+	case d.Line > 0 && d.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+	case d.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+	default:
+		return fmt.Sprintf("%s: %s", d.File, d.Message)
+	}
+}
+
+// toDiagnostics converts an error raised while handling file into diagnostics,
+// one per entry when err is a go/scanner error list.
+func toDiagnostics(file string, err error) (diagnostics []Diagnostic) {
+	var list scanner.ErrorList
+	if !errors.As(err, &list) {
+		return []Diagnostic{{File: file, Message: err.Error()}}
+	}
+	for _, e := range list {
+		diagnostics = append(diagnostics, Diagnostic{File: file, Line: e.Pos.Line, Column: e.Pos.Column, Message: e.Msg})
+	}
+	return
+}
+
+type funcDecl struct {
+	name     string
+	line     int
+	endLine  int
+	doc      string
+	bodyHash string
+	// invalid holds the reason why the function is not a runnable benchmark,
+	// empty when it is one.
+	invalid       string
+	directives    Directives
+	directiveErrs []Diagnostic
+}
+
+func funcNames(filename string, exportOnly bool) ([]funcDecl, error) {
 	fset := token.NewFileSet()
-	file, _ := parser.ParseFile(fset, filename, nil, 0)
+	file, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
 	if exportOnly {
 		ast.FileExports(file) // trim AST
 	}
 
-	funcNames := []string{}
+	testingName := testingImportName(file)
+	funcNames := []funcDecl{}
 	for _, decl := range file.Decls {
 		fn, ok := decl.(*ast.FuncDecl) // assert type of declaration
 		if !ok {
 			continue
 		}
-		funcNames = append(funcNames, fn.Name.Name)
+		directives, directiveErrs := parseDirectives(fset, fn.Doc)
+		funcNames = append(funcNames, funcDecl{
+			name:          fn.Name.Name,
+			line:          fset.Position(fn.Pos()).Line,
+			endLine:       fset.Position(fn.End()).Line,
+			doc:           strings.TrimSpace(fn.Doc.Text()),
+			bodyHash:      bodyHash(fset, fn.Body),
+			invalid:       benchmarkSignature(fn, testingName),
+			directives:    directives,
+			directiveErrs: directiveErrs,
+		})
+	}
+	return funcNames, nil
+}
+
+// bodyHash returns the hex encoded SHA-256 of the gofmt-ed function body,
+// or an empty string for functions without body.
+func bodyHash(fset *token.FileSet, body *ast.BlockStmt) string {
+	if body == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, body); err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// testingImportName returns the name under which the file imports the testing
+// package: "testing" by default, the alias if renamed, "." for dot imports and
+// an empty string if it is not imported at all.
+func testingImportName(file *ast.File) string {
+	for _, imp := range file.Imports {
+		if imp.Path.Value != `"testing"` {
+			continue
+		}
+		if imp.Name != nil {
+			return imp.Name.Name
+		}
+		return "testing"
 	}
-	return funcNames
+	return ""
 }
 
-func GetBenchmarks(root string) ([]string, error) {
-	var data []string
+// benchmarkSignature checks that fn can be run by go test as a benchmark,
+// i.e. it is a plain BenchmarkXxx function with the func(*testing.B) signature.
+// It returns the reason why it cannot, or an empty string.
+func benchmarkSignature(fn *ast.FuncDecl, testingName string) string {
+	name := fn.Name.Name
+	if len(name) > len("Benchmark") {
+		if r, _ := utf8.DecodeRuneInString(name[len("Benchmark"):]); unicode.IsLower(r) {
+			return "name does not follow the BenchmarkXxx convention"
+		}
+	}
+	if fn.Recv != nil {
+		return "is a method"
+	}
+	if fn.Type.TypeParams != nil {
+		return "has type parameters"
+	}
+	if fn.Type.Results != nil && len(fn.Type.Results.List) > 0 {
+		return "has results"
+	}
+	params := fn.Type.Params.List
+	if len(params) != 1 || len(params[0].Names) > 1 {
+		return "does not have the func(*testing.B) signature"
+	}
+	star, ok := params[0].Type.(*ast.StarExpr)
+	if !ok {
+		return "does not have the func(*testing.B) signature"
+	}
+	switch t := star.X.(type) {
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok && testingName != "" && pkg.Name == testingName && t.Sel.Name == "B" {
+			return ""
+		}
+	case *ast.Ident:
+		if testingName == "." && t.Name == "B" {
+			return ""
+		}
+	}
+	return "does not have the func(*testing.B) signature"
+}
+
+// skipDir reports whether the go tool ignores the directory with the given name
+// when matching ./... patterns.
+func skipDir(name string) bool {
+	return name == "vendor" || name == "testdata" || strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".")
+}
+
+// GetBenchmarks walks root and returns every benchmark found in its _test.go
+// files, along with the module, package directory, file and line it was
+// declared at. Every directory holding a go.mod file starts a new module; when
+// root holds a go.work file, modules it does not use are excluded.
+// vendor, testdata and _ or . prefixed directories are skipped. Only files
+// matched by buildCtx (build constraints, GOOS/GOARCH suffixes and tags) are
+// considered. Benchmark-prefixed functions which go test would not run are
+// reported as exclusions, and files which cannot be read or parsed as
+// diagnostics, without stopping the walk.
+func GetBenchmarks(root string, buildCtx build.Context) (Discovery, error) {
+	var data Discovery
+	modules := moduleSet{}
+	workspace, hasWorkspace := workspaceModules(filepath.Join(root, "go.work"))
 	fileSystem := os.DirFS(root)
-	err := fs.WalkDir(fileSystem, ".", func(path string, d fs.DirEntry, err error) error {
+	err := fs.WalkDir(fileSystem, ".", func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
-			log.Fatal(err)
+			// Unreadable entry: record it and keep walking the rest of the tree.
+			data.Diagnostics = append(data.Diagnostics, toDiagnostics(p, err)...)
+			if d != nil && d.IsDir() && p != "." {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if p != "." && skipDir(d.Name()) {
+				return fs.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(p), "go.mod")); err == nil {
+				module := Module{Path: modulePath(filepath.Join(root, filepath.FromSlash(p), "go.mod")), Dir: p}
+				if hasWorkspace && !slices.Contains(workspace, p) {
+					module.Excluded = fmt.Sprintf("module %s is not used by go.work", module.Path)
+				}
+				modules[p] = module
+				data.Modules = append(data.Modules, module)
+			}
+			return nil
+		}
+		if filepath.Ext(p) != ".go" {
+			return nil
+		}
+		if !strings.HasSuffix(path.Base(p), "_test.go") {
+			return nil
 		}
-		if filepath.Ext(path) != ".go" {
+		dir := path.Dir(p)
+		module, _ := modules.lookup(dir)
+		match, err := buildCtx.MatchFile(filepath.Join(root, filepath.FromSlash(dir)), path.Base(p))
+		if err != nil {
+			data.Diagnostics = append(data.Diagnostics, toDiagnostics(p, err)...)
 			return nil
 		}
-		if !strings.HasSuffix(filepath.Base(path), "_test.go") {
+		fnames, err := funcNames(filepath.Join(root, filepath.FromSlash(p)), false)
+		if err != nil {
+			data.Diagnostics = append(data.Diagnostics, toDiagnostics(p, err)...)
 			return nil
 		}
-		fnames := funcNames(path, false)
 		for _, fname := range fnames {
-			if strings.HasPrefix(fname, "Benchmark") {
-				data = append(data, fname)
+			if !strings.HasPrefix(fname.name, "Benchmark") {
+				continue
+			}
+			for _, directiveErr := range fname.directiveErrs {
+				directiveErr.File = p
+				data.DirectiveErrors = append(data.DirectiveErrors, directiveErr)
+			}
+			reason := fname.invalid
+			if fname.directives.Skip {
+				reason = "skipped by the " + directivePrefix + "skip directive"
+			}
+			if module.Excluded != "" {
+				reason = module.Excluded
 			}
+			if !match {
+				reason = fmt.Sprintf("file excluded by build constraints for %s/%s with tags %v", buildCtx.GOOS, buildCtx.GOARCH, buildCtx.BuildTags)
+			}
+			if reason != "" {
+				data.Excluded = append(data.Excluded, Exclusion{Name: fname.name, File: p, Line: fname.line, Reason: reason})
+				continue
+			}
+			data.Benchmarks = append(data.Benchmarks, Benchmark{
+				Name:       fname.name,
+				Dir:        dir,
+				ImportPath: packageImportPath(module.Path, module.relDir(dir)),
+				Module:     module.Path,
+				ModuleDir:  module.Dir,
+				File:       p,
+				Line:       fname.line,
+				EndLine:    fname.endLine,
+				Doc:        fname.doc,
+				BodyHash:   fname.bodyHash,
+				Directives: fname.directives,
+			})
 		}
 		return nil
 	})
 	return data, err
 }
+
+// benchBuildContext returns the build context used for benchmark discovery,
+// i.e. the default one for the host extended with the given build tags.
+func benchBuildContext(tags []string) build.Context {
+	buildCtx := build.Default
+	buildCtx.BuildTags = append(buildCtx.BuildTags, tags...)
+	return buildCtx
+}
@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// withoutSourceMetadata clears the fields covered by TestGetBenchmarks_metadata.
+func withoutSourceMetadata(benchmarks []Benchmark) []Benchmark {
+	for i := range benchmarks {
+		benchmarks[i].EndLine = 0
+		benchmarks[i].Doc = ""
+		benchmarks[i].BodyHash = ""
+	}
+	return benchmarks
+}
+
+func TestGetBenchmarks(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod": "module example.com/mono\n\ngo 1.21\n",
+		"root_test.go": `package mono
+
+import "testing"
+
+func BenchmarkRoot(b *testing.B) {}
+`,
+		"internal/store/store_test.go": `package store
+
+import "testing"
+
+func TestGet(t *testing.T) {}
+
+func BenchmarkGet(b *testing.B) {}
+
+func BenchmarkSetup(x int) {}
+
+func Benchmarkhelper(b *testing.B) {}
+
+type s struct{}
+
+func (s) BenchmarkMethod(b *testing.B) {}
+`,
+		"vendor/example.com/dep/dep_test.go": `package dep
+
+import "testing"
+
+func BenchmarkVendored(b *testing.B) {}
+`,
+		"internal/store/testdata/fixture_test.go": `package fixture
+
+import "testing"
+
+func BenchmarkFixture(b *testing.B) {}
+`,
+		"_old/old_test.go": `package old
+
+import "testing"
+
+func BenchmarkOld(b *testing.B) {}
+`,
+		"internal/broken/broken_test.go": `package broken
+
+import "testing"
+
+func BenchmarkBroken(b *testing.B) {
+`,
+		"internal/store/alias_test.go": `package store_test
+
+import tt "testing"
+
+func BenchmarkAlias(b *tt.B) {}
+`,
+		"internal/store/integration_test.go": `//go:build integration
+
+package store
+
+import "testing"
+
+func BenchmarkIntegration(b *testing.B) {}
+`,
+		"internal/store/store_plan9_test.go": `package store
+
+import "testing"
+
+func BenchmarkPlan9(b *testing.B) {}
+`,
+	})
+	buildCtx := benchBuildContext(nil)
+	buildCtx.GOOS = "linux"
+	got, err := GetBenchmarks(root, buildCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Benchmark{
+		{Name: "BenchmarkAlias", Dir: "internal/store", ImportPath: "example.com/mono/internal/store", Module: "example.com/mono", ModuleDir: ".", File: "internal/store/alias_test.go", Line: 5},
+		{Name: "BenchmarkGet", Dir: "internal/store", ImportPath: "example.com/mono/internal/store", Module: "example.com/mono", ModuleDir: ".", File: "internal/store/store_test.go", Line: 7},
+		{Name: "BenchmarkRoot", Dir: ".", ImportPath: "example.com/mono", Module: "example.com/mono", ModuleDir: ".", File: "root_test.go", Line: 5},
+	}
+	if !reflect.DeepEqual(withoutSourceMetadata(got.Benchmarks), want) {
+		t.Errorf("GetBenchmarks() benchmarks = %+v, want %+v", got.Benchmarks, want)
+	}
+	if len(got.Diagnostics) == 0 || got.Diagnostics[0].File != "internal/broken/broken_test.go" || got.Diagnostics[0].Line == 0 {
+		t.Errorf("GetBenchmarks() diagnostics = %+v, want a positioned diagnostic for internal/broken/broken_test.go", got.Diagnostics)
+	}
+	excluded := map[string]bool{}
+	for _, e := range got.Excluded {
+		excluded[e.Name] = true
+	}
+	for _, name := range []string{"BenchmarkIntegration", "BenchmarkPlan9", "BenchmarkSetup", "Benchmarkhelper", "BenchmarkMethod"} {
+		if !excluded[name] {
+			t.Errorf("GetBenchmarks() did not exclude %s. Exclusions: %+v", name, got.Excluded)
+		}
+	}
+
+	got, err = GetBenchmarks(root, benchBuildContext([]string{"integration"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, b := range got.Benchmarks {
+		found = found || b.Name == "BenchmarkIntegration"
+	}
+	if !found {
+		t.Errorf("GetBenchmarks() with tags did not include BenchmarkIntegration. Benchmarks: %+v", got.Benchmarks)
+	}
+}
+
+func TestGetBenchmarks_workspace(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.work":             "go 1.21\n\nuse (\n\t./services/api // the API\n\t./libs/codec\n)\n",
+		"services/api/go.mod": "module example.com/api\n\ngo 1.21\n",
+		"services/api/handler/handler_test.go": `package handler
+
+import "testing"
+
+func BenchmarkHandle(b *testing.B) {}
+`,
+		"libs/codec/go.mod": "module example.com/codec\n\ngo 1.21\n",
+		"libs/codec/codec_test.go": `package codec
+
+import "testing"
+
+func BenchmarkEncode(b *testing.B) {}
+`,
+		"tools/go.mod": "module example.com/tools\n\ngo 1.21\n",
+		"tools/tools_test.go": `package tools
+
+import "testing"
+
+func BenchmarkTool(b *testing.B) {}
+`,
+	})
+	got, err := GetBenchmarks(root, benchBuildContext(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Benchmark{
+		{Name: "BenchmarkEncode", Dir: "libs/codec", ImportPath: "example.com/codec", Module: "example.com/codec", ModuleDir: "libs/codec", File: "libs/codec/codec_test.go", Line: 5},
+		{Name: "BenchmarkHandle", Dir: "services/api/handler", ImportPath: "example.com/api/handler", Module: "example.com/api", ModuleDir: "services/api", File: "services/api/handler/handler_test.go", Line: 5},
+	}
+	if !reflect.DeepEqual(withoutSourceMetadata(got.Benchmarks), want) {
+		t.Errorf("GetBenchmarks() benchmarks = %+v, want %+v", got.Benchmarks, want)
+	}
+	if len(got.Excluded) != 1 || got.Excluded[0].Name != "BenchmarkTool" {
+		t.Errorf("GetBenchmarks() exclusions = %+v, want BenchmarkTool only", got.Excluded)
+	}
+	if pkg := want[1].ModulePackage(); pkg != "./handler" {
+		t.Errorf("ModulePackage() = %v, want ./handler", pkg)
+	}
+}
+
+func TestGetBenchmarks_metadata(t *testing.T) {
+	discover := func(src string) Benchmark {
+		root := t.TempDir()
+		writeFiles(t, root, map[string]string{"go.mod": "module example.com/m\n", "m_test.go": src})
+		got, err := GetBenchmarks(root, benchBuildContext(nil))
+		if err != nil || len(got.Benchmarks) != 1 {
+			t.Fatalf("GetBenchmarks() = %+v, %v", got, err)
+		}
+		return got.Benchmarks[0]
+	}
+	base := discover(`package m
+
+import "testing"
+
+// BenchmarkSum measures
+// summing integers.
+//
+//codeperf:count=2
+func BenchmarkSum(b *testing.B) {
+	s := 0
+	for i := 0; i < b.N; i++ {
+		s += i
+	}
+}
+`)
+	if base.Doc != "BenchmarkSum measures\nsumming integers." {
+		t.Errorf("Doc = %q", base.Doc)
+	}
+	if base.Line != 9 || base.EndLine != 14 {
+		t.Errorf("Line, EndLine = %d, %d, want 9, 14", base.Line, base.EndLine)
+	}
+	reformatted := discover(`package m
+
+import "testing"
+
+func BenchmarkSum(b *testing.B) {
+	s := 0 // accumulator
+	for i := 0; i < b.N; i++ { s += i }
+}
+`)
+	if reformatted.BodyHash != base.BodyHash {
+		t.Errorf("BodyHash changed on formatting and comment only changes")
+	}
+	changed := discover(`package m
+
+import "testing"
+
+func BenchmarkSum(b *testing.B) {
+	s := 0
+	for i := 0; i < b.N; i++ {
+		s -= i
+	}
+}
+`)
+	if changed.BodyHash == base.BodyHash {
+		t.Errorf("BodyHash did not change on code changes")
+	}
+}
+
+func Test_packageImportPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		module string
+		dir    string
+		want   string
+	}{
+		{"root", "example.com/mono", ".", "example.com/mono"},
+		{"nested", "example.com/mono", "internal/store", "example.com/mono/internal/store"},
+		{"no-module-root", "", ".", "."},
+		{"no-module-nested", "", "internal/store", "./internal/store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := packageImportPath(tt.module, tt.dir); got != tt.want {
+				t.Errorf("packageImportPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// resume is set by the --resume flag.
+var resume bool
+
+// stateFile is the name of the run state file of the output directory.
+const stateFile = "state.json"
+
+// state records the benchmarks completed by the test runs of the output
+// directory.
+var state *runState
+
+// completedBenchmark records a benchmark which completed successfully, for
+// --resume to skip it.
+type completedBenchmark struct {
+	// InputsHash identifies the test binary inputs, run configuration and
+	// hardware the benchmark ran with.
+	InputsHash string `json:"inputsHash"`
+	// RunDir is the run directory holding the artifacts, relative to the
+	// output directory.
+	RunDir    string     `json:"runDir"`
+	Artifacts []Artifact `json:"artifacts"`
+	// Uploaded is false when the results were only printed, with --local.
+	Uploaded    bool      `json:"uploaded"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// runState records the completed benchmarks of every commit, by benchmark
+// key.
+type runState struct {
+	Commits map[string]map[string]completedBenchmark `json:"commits"`
+	// base is the output directory.
+	base string
+}
+
+// loadRunState reads the state file of the output directory base, if any.
+func loadRunState(base string) (*runState, error) {
+	s := &runState{Commits: map[string]map[string]completedBenchmark{}, base: base}
+	content, err := os.ReadFile(filepath.Join(base, stateFile))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, s); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v", filepath.Join(base, stateFile), err)
+	}
+	if s.Commits == nil {
+		s.Commits = map[string]map[string]completedBenchmark{}
+	}
+	return s, nil
+}
+
+// save writes the state file, through a rename so that a run killed midway
+// never leaves it truncated.
+func (s *runState) save() error {
+	name := filepath.Join(s.base, stateFile)
+	if err := writeJSONFile(name+".tmp", s); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// benchmarkKey identifies a benchmark within a commit.
+func benchmarkKey(b Benchmark) string {
+	return b.ImportPath + " " + b.RunName()
+}
+
+// record saves the completion of the benchmark at the given commit.
+func (s *runState) record(commit string, b Benchmark, completed completedBenchmark) error {
+	if s.Commits[commit] == nil {
+		s.Commits[commit] = map[string]completedBenchmark{}
+	}
+	s.Commits[commit][benchmarkKey(b)] = completed
+	return s.save()
+}
+
+// completed returns the previous completion of the benchmark at the given
+// commit, nil if there is none. It fails if the benchmark since changed
+// inputs, its results were not uploaded while --local is not set, or its
+// artifacts are no longer intact.
+func (s *runState) completed(commit string, b Benchmark, inputsHash string) (*completedBenchmark, error) {
+	completed, ok := s.Commits[commit][benchmarkKey(b)]
+	switch {
+	case !ok:
+		return nil, nil
+	case completed.InputsHash != inputsHash:
+		return nil, fmt.Errorf("its inputs changed since %s", completed.RunDir)
+	case !completed.Uploaded && !local:
+		return nil, fmt.Errorf("the results of %s were not uploaded", completed.RunDir)
+	}
+	for _, artifact := range completed.Artifacts {
+		if err := verifyArtifact(filepath.Join(s.base, completed.RunDir), artifact); err != nil {
+			return nil, fmt.Errorf("invalid artifact of %s: %v", completed.RunDir, err)
+		}
+	}
+	return &completed, nil
+}
+
+// benchmarkInputsHash hashes everything the results of the benchmark depend
+// on: the inputs of its test binary, which is named after their hash, the
+// benchmark itself, the run flags, the isolation and the hardware.
+func benchmarkInputsHash(binary string, b Benchmark) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", filepath.Base(binary))
+	err := json.NewEncoder(h).Encode(struct {
+		Benchmark            Benchmark
+		Benchtime            string
+		Count                int
+		Profiles             []string
+		BlockProfileRate     int
+		MutexProfileFraction int
+		Trace                bool
+		RunFlags             []string
+		Isolation            *Isolation
+		HardwareID           string
+	}{b, benchtime, count, profiles, blockProfileRate, mutexProfileFraction, trace, passThrough.Run, isolation, fingerprint.HardwareID})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_runState(t *testing.T) {
+	defer func(l bool) { local = l }(local)
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"run1/benchmarks/BenchmarkGet/cpuprofile.out": "cpu"})
+	artifact, err := fileArtifact(filepath.Join(base, "run1"), filepath.Join(base, "run1", "benchmarks", "BenchmarkGet", "cpuprofile.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := Benchmark{Name: "BenchmarkGet", ImportPath: "example.com/store"}
+	put := Benchmark{Name: "BenchmarkPut", ImportPath: "example.com/store"}
+
+	s, err := loadRunState(base)
+	if err != nil {
+		t.Fatalf("loadRunState() error = %v", err)
+	}
+	if err := s.record("abc1234", get, completedBenchmark{InputsHash: "h1", RunDir: "run1", Artifacts: []Artifact{artifact}}); err != nil {
+		t.Fatalf("record() error = %v", err)
+	}
+	if s, err = loadRunState(base); err != nil {
+		t.Fatalf("loadRunState() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		commit  string
+		b       Benchmark
+		hash    string
+		local   bool
+		want    bool
+		wantErr bool
+	}{
+		{"completed", "abc1234", get, "h1", true, true, false},
+		{"not uploaded", "abc1234", get, "h1", false, false, true},
+		{"inputs changed", "abc1234", get, "h2", true, false, true},
+		{"other benchmark", "abc1234", put, "h1", true, false, false},
+		{"other commit", "def5678", get, "h1", true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local = tt.local
+			got, err := s.completed(tt.commit, tt.b, tt.hash)
+			if (err != nil) != tt.wantErr || (got != nil) != tt.want {
+				t.Errorf("completed() = %v, %v, want completed %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+
+	local = true
+	if err := os.WriteFile(filepath.Join(base, "run1", "benchmarks", "BenchmarkGet", "cpuprofile.out"), []byte("CPU"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.completed("abc1234", get, "h1"); got != nil || err == nil {
+		t.Errorf("completed() = %v, %v, want an error once the profile changed", got, err)
+	}
+}
+
+func Test_runState_sameNameInTwoPackages(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+	defer func(l bool) { local = l }(local)
+	local = true
+	base := t.TempDir()
+	output, err := newRunOutput(base, nil)
+	if err != nil {
+		t.Fatalf("newRunOutput() error = %v", err)
+	}
+	s, err := loadRunState(base)
+	if err != nil {
+		t.Fatalf("loadRunState() error = %v", err)
+	}
+	a := Benchmark{Name: "BenchmarkGet", Dir: "a", ImportPath: "example.com/mono/a", ModuleDir: "."}
+	b := Benchmark{Name: "BenchmarkGet", Dir: "b", ImportPath: "example.com/mono/b", ModuleDir: "."}
+	for _, bench := range []Benchmark{a, b} {
+		dir, err := output.benchmarkDir(bench)
+		if err != nil {
+			t.Fatalf("benchmarkDir() error = %v", err)
+		}
+		writeFiles(t, dir, map[string]string{"cpuprofile.out": bench.ImportPath})
+		artifact, err := fileArtifact(output.Dir, filepath.Join(dir, "cpuprofile.out"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.record("abc1234", bench, completedBenchmark{InputsHash: "h1", RunDir: filepath.Base(output.Dir), Artifacts: []Artifact{artifact}}); err != nil {
+			t.Fatalf("record() error = %v", err)
+		}
+	}
+	for _, bench := range []Benchmark{a, b} {
+		if got, err := s.completed("abc1234", bench, "h1"); got == nil || err != nil {
+			t.Errorf("completed(%s) = %v, %v, want completed", bench.ImportPath, got, err)
+		}
+	}
+}
@@ -17,6 +17,7 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/go-git/go-git/v5"
@@ -24,7 +25,9 @@ import (
 	"log"
 	"net/http"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"os"
@@ -43,6 +46,18 @@ var codeperfUrl string
 var codeperfApiUrl string
 var benchtime string
 var local bool
+var subBenchmarks bool
+var tags []string
+var skipBench string
+var failOnParseErrors bool
+var since string
+var count int
+var profiles []string
+var trace bool
+var timeout time.Duration
+
+// gitRepository is the repository of the current directory, nil if there is none.
+var gitRepository *git.Repository
 var longDescription = `                  __                     ____        _
   _________  ____/ /__  ____  ___  _____/ __/       (_)___
  / ___/ __ \/ __  / _ \/ __ \/ _ \/ ___/ /_        / / __ \
@@ -60,7 +75,7 @@ var rootCmd = &cobra.Command{
 }
 
 var cmdPrint = &cobra.Command{
-	Use:   "test",
+	Use:   "test [packages]",
 	Short: "Print anything to the screen",
 	Long: `print is for printing anything back to the screen.
 For many years people have printed back to the screen.`,
@@ -70,72 +85,268 @@ For many years people have printed back to the screen.`,
 
 func testLogic(cmd *cobra.Command, args []string) {
 	// TODO: Check pprof is available on path
-	const shell = "/bin/bash"
-	benchmarks, _ := GetBenchmarks(".")
-	var err error = nil
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	ctx, cancel := withOptionalTimeout(ctx, timeout)
+	defer cancel()
+	var err error
+	output, err = newRunOutput(outputDir, os.Args[1:])
+	if err != nil {
+		log.Fatalf("Unable to create the run output directory. Error: %v", err)
+	}
+	if state, err = loadRunState(outputDir); err != nil {
+		log.Fatalf("Unable to read the run state. Error: %v", err)
+	}
+	discovery, err := GetBenchmarks(".", benchBuildContext(tags))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, module := range discovery.Modules {
+		log.Printf("Detected module %s (%s).", module.Path, module.Dir)
+	}
+	for _, excluded := range discovery.Excluded {
+		log.Printf("Skipping %s (%s:%d): %s.", excluded.Name, excluded.File, excluded.Line, excluded.Reason)
+	}
+	if len(discovery.DirectiveErrors) > 0 {
+		for _, directiveErr := range discovery.DirectiveErrors {
+			log.Printf("\t%s", directiveErr)
+		}
+		log.Fatalf("Found %d invalid %s directives.", len(discovery.DirectiveErrors), directivePrefix)
+	}
+	if len(discovery.Diagnostics) > 0 {
+		log.Printf("Unable to read or parse %d files during benchmark discovery:", len(discovery.Diagnostics))
+		for _, diagnostic := range discovery.Diagnostics {
+			log.Printf("\t%s", diagnostic)
+		}
+		if failOnParseErrors {
+			log.Fatalf("Aborting given --fail-on-parse-errors was specified.")
+		}
+	}
+	patterns := args
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	if _, err := parseProfileKinds(profiles); err != nil {
+		log.Fatalf("Invalid --profiles: %v", err)
+	}
+	if args, ok := configStringSlice(goTestArgsConfigKey); ok && !cmd.Flags().Changed("go-test-arg") {
+		goTestArgs = args
+	}
+	if passThrough, err = parseGoTestArgs(goTestArgs); err != nil {
+		log.Fatalf("Invalid --go-test-arg: %v", err)
+	}
+	if env, ok := configStringSlice(testEnvConfigKey); ok && !cmd.Flags().Changed("env") {
+		testEnv = env
+	}
+	if err := validateEnv(testEnv); err != nil {
+		log.Fatalf("Invalid --env: %v", err)
+	}
+	if isolation, err = newIsolation(cpuset, nice, sched); err != nil {
+		log.Fatalf("Invalid benchmark isolation: %v", err)
+	}
+	if isolation != nil {
+		output.manifest.Isolation = isolation
+		log.Printf("Running the benchmarks with %s.", isolation)
+	}
+	if err := validateProcs(cpus); err != nil {
+		log.Fatalf("Invalid --cpu: %v", err)
+	}
+	if count < 1 {
+		log.Fatalf("--count must be at least 1, got %d.", count)
+	}
+	filter, err := newBenchFilter(bench, skipBench)
+	if err != nil {
+		log.Fatal(err)
+	}
+	benchmarks := filterBenchmarks(discovery.Benchmarks, patterns, filter)
 
 	goPath, err := exec.LookPath("go")
+	if fingerprint, err = collectFingerprint(goPath); err != nil {
+		log.Fatalf("Unable to fingerprint the environment. Error: %v", err)
+	}
+	output.manifest.Environment = fingerprint
+	log.Printf("Running on %s (hardware id %s).", fingerprint, fingerprint.HardwareID)
+	if trace {
+		if err := checkTraceToolchain(fingerprint.GoVersion); err != nil {
+			log.Fatalf("Invalid --trace: %v", err)
+		}
+	}
+	output.manifest.Warnings = readHostState().warnings()
+	for _, warning := range output.manifest.Warnings {
+		log.Printf("Warning: %s.", warning)
+	}
+	if strict && len(output.manifest.Warnings) > 0 {
+		log.Fatalf("Aborting as --strict is set and %d pre-flight checks failed.", len(output.manifest.Warnings))
+	}
+
+	if since != "" {
+		benchmarks, err = selectChangedBenchmarks(gitRepository, goPath, discovery, benchmarks, since)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if subBenchmarks {
+		benchmarks = filterBenchmarks(expandSubBenchmarks(ctx, goPath, tags, benchmarks), patterns, filter)
+	}
 
 	log.Println(fmt.Sprintf("Detected %d distinct benchmarks.", len(benchmarks)))
+	benchmarks = expandProcs(benchmarks, cpus)
+	var summary runSummary
 	for _, benchmark := range benchmarks {
-
-		cpuProfileName := fmt.Sprintf("cpuprofile-%s.out", benchmark)
-		cmdS := fmt.Sprintf("%s test -bench=%s -benchtime=%s -cpuprofile %s .", goPath, benchmark, benchtime, cpuProfileName)
-		log.Println(fmt.Sprintf("Running benchmark %s with the following command: %s.", benchmark, cmdS))
-		err := exec.Command(shell, "-c", cmdS).Run()
+		outcome, commands := testBenchmark(ctx, goPath, benchmark)
+		if outcome.Err != nil {
+			log.Printf("Benchmark %s %s: %v", benchmark.RunName(), outcome.Status, outcome.Err)
+		}
+		summary.add(outcome)
+		entry, err := output.addBenchmark(outcome, commands)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("Unable to update the run manifest. Error: %v", err)
+		}
+		if outcome.Status == statusPassed {
+			completed := completedBenchmark{
+				InputsHash:  outcome.InputsHash,
+				RunDir:      filepath.Base(output.Dir),
+				Artifacts:   entry.Artifacts,
+				Uploaded:    !local,
+				CompletedAt: time.Now().UTC(),
+			}
+			if err := state.record(gitCommit, benchmark, completed); err != nil {
+				log.Fatalf("Unable to update the run state. Error: %v", err)
+			}
 		}
-		granularityOptions := []string{"lines", "functions"}
-		exportFromPprof(cpuProfileName, benchmark, granularityOptions)
-	}
-	coverprofile := "coverage.out"
-	cmdS := fmt.Sprintf("%s test -cover -bench=. -benchtime=0.01s -coverprofile %s .", goPath, coverprofile)
-	log.Println(fmt.Sprintf("Calculating the project benchmark coverage with the following command: %s.", cmdS))
-	c := exec.Command(shell, "-c", cmdS)
-	var outb, errb bytes.Buffer
-	c.Stdout = &outb
-	c.Stderr = &errb
-	err = c.Run()
+	}
+	// Failures past the benchmarks are only logged here, for the run summary
+	// to always be printed. They set the exit code unless a benchmark failed.
+	failed := false
+	coverageVs, err := benchmarkCoverage(ctx, goPath, summary.Outcomes)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Unable to calculate the project benchmark coverage. Error: %v", err)
+		failed = true
+	}
+	if err := output.writeManifest(); err != nil {
+		log.Printf("Unable to update the run manifest. Error: %v", err)
+		failed = true
+	}
+	if coverageVs != "" {
+		log.Printf("Benchmark coverage: %s%% of statements.", coverageVs)
+		if err := exportCoverage(coverageVs); err != nil {
+			log.Printf("Unable to export the project benchmark coverage. Error: %v", err)
+			failed = true
+		}
 	}
 
-	cleaned := strings.TrimSpace(string(outb.String()))
-	lines := strings.Split(cleaned, "\n")
-	coverageLine := lines[len(lines)-2]
-	coverageLineS := strings.Split(coverageLine, ":")
-	coverageVs := strings.Split(strings.TrimSpace(coverageLineS[1]), " ")[0]
-	coverageVs = coverageVs[0 : len(coverageVs)-1]
-	fmt.Println(coverageLine, coverageVs)
-	exportCoverage(coverageVs)
+	summary.print(log.Writer())
+	if summary.count(statusFailed)+summary.count(statusSkipped) > 0 {
+		os.Exit(benchmarksFailedExitCode)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// testBenchmark builds, runs and exports the benchmark, returning its outcome
+// along with the commands run. Failures are reported in the outcome rather
+// than aborting the test command, for the remaining benchmarks to still run.
+// Every benchmark of a package whose test binary failed to build fails with
+// the build error, the build being attempted once.
+func testBenchmark(ctx context.Context, goPath string, benchmark Benchmark) (outcome benchmarkOutcome, commands []string) {
+	start := time.Now()
+	outcome = benchmarkOutcome{Benchmark: benchmark, Status: statusFailed}
+	defer func() {
+		outcome.Duration = time.Since(start)
+	}()
+	if ctx.Err() != nil {
+		outcome.Status, outcome.Err = statusSkipped, fmt.Errorf("not run, the test command was %v", contextError(ctx))
+		return
+	}
+	binary, err := testBinary(ctx, goPath, tags, benchmark)
+	if err != nil {
+		outcome.Err = err
+		return
+	}
+	if outcome.InputsHash, err = benchmarkInputsHash(binary, benchmark); err != nil {
+		outcome.Err = err
+		return
+	}
+	if resume {
+		cached, err := state.completed(gitCommit, benchmark, outcome.InputsHash)
+		if err != nil {
+			log.Printf("Running %s again: %v.", benchmark.RunName(), err)
+		} else if cached != nil {
+			log.Printf("Skipping %s of %s, completed with the same inputs in %s.", benchmark.RunName(), benchmark.ImportPath, cached.RunDir)
+			outcome.Status, outcome.Cached = statusCached, cached
+			return
+		}
+	}
+	dir, err := output.benchmarkDir(benchmark)
+	if err != nil {
+		outcome.Err = err
+		return
+	}
+	run, err := runBenchmark(ctx, goPath, binary, dir, benchmark)
+	commands = run.Commands
+	if err != nil {
+		outcome.Err = err
+		return
+	}
+	for _, kind := range benchmarkProfiles(benchmark) {
+		for _, sampleType := range kind.exportedSampleTypes() {
+			granularityOptions := []string{"lines", "functions"}
+			if err := exportFromPprof(run.Profiles[kind.Name], binary, benchmark.RunName(), exportScope(benchmark), kind.exportPath(sampleType), sampleType, granularityOptions, filepath.Join(dir, "reports")); err != nil {
+				outcome.Err = fmt.Errorf("unable to export the %s profile: %v", kind.exportPath(sampleType), err)
+				return
+			}
+		}
+	}
+	if err := exportBenchmarkMetadata(benchmark); err != nil {
+		outcome.Err = fmt.Errorf("unable to export the metadata: %v", err)
+		return
+	}
+	if err := exportBenchmarkResults(benchmark, run.Results); err != nil {
+		outcome.Err = fmt.Errorf("unable to export the results: %v", err)
+		return
+	}
+	if trace {
+		if err := exportBenchmarkTrace(benchmark, run.Traces); err != nil {
+			outcome.Err = fmt.Errorf("unable to export the trace summary: %v", err)
+			return
+		}
+	}
+	outcome.Status = statusPassed
+	return
 }
 
-func exportCoverage(vs string) {
+// exportCoverage publishes the benchmark coverage percentage of the branch, or
+// only prints it when --local is set.
+func exportCoverage(vs string) error {
 	postBody, err := json.Marshal(map[string]string{"coverage": vs})
 	if err != nil {
-		log.Fatalf("An Error Occured %v", err)
+		return err
 	}
 	fmt.Println(string(postBody))
+	if local {
+		return nil
+	}
 
 	responseBody := bytes.NewBuffer(postBody)
 	endPoint := fmt.Sprintf("%s/v1/gh/%s/%s/branch/%s/graph", codeperfApiUrl, gitOrg, gitRepo, gitBranch)
 	resp, err := http.Post(endPoint, "application/json", responseBody)
 	//Handle Error
 	if err != nil {
-		log.Fatalf("An Error Occured %v", err)
+		return err
 	}
 	defer resp.Body.Close()
 
 	//Read the response body
 	reply, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 	if resp.StatusCode != 200 {
-		log.Fatalf("An error ocurred while pushing cpu data to remote %s.\nEndpoint %s. Status code %d. Reply: %s", codeperfApiUrl, endPoint, resp.StatusCode, string(reply))
+		return fmt.Errorf("an error ocurred while pushing the coverage to remote %s.\nEndpoint %s. Status code %d. Reply: %s", codeperfApiUrl, endPoint, resp.StatusCode, string(reply))
 	}
-
+	return nil
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -156,6 +367,7 @@ func init() {
 	if err != nil {
 		log.Println("Unable to retrieve current repo git info. Use the --git-org, --git-repo, --git-branch and --git-hash to properly fill the git info.")
 	} else {
+		gitRepository = r
 		ref, _ := r.Head()
 		if ref.Name().IsBranch() {
 			defaultGitBranch = ref.Name().Short()
@@ -181,11 +393,32 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&gitRepo, "git-repo", defaultGitRepo, "git repo")
 	rootCmd.PersistentFlags().StringVar(&gitCommit, "git-hash", defaultGitCommit, "git commit hash")
 	rootCmd.PersistentFlags().StringVar(&gitBranch, "git-branch", defaultGitBranch, "git branch")
-	rootCmd.PersistentFlags().StringVar(&localFilename, "local-filename", "profile.json", "Local file to export the json to. Only used when the --local flag is set")
+	rootCmd.PersistentFlags().StringVar(&localFilename, "local-filename", "profile.json", "Local file to export the json to. Only used by the export command when the --local flag is set, the test command writes its reports to the run directory")
 	rootCmd.PersistentFlags().StringVar(&codeperfUrl, "codeperf-url", "https://codeperf.io", "codeperf URL")
 	rootCmd.PersistentFlags().StringVar(&codeperfApiUrl, "codeperf-api-url", "https://api.codeperf.io", "codeperf API URL")
 	rootCmd.PersistentFlags().StringVar(&benchtime, "benchtime", "10s", "benchmark time")
-	rootCmd.PersistentFlags().StringVar(&bench, "bench", "", "Benchmark name")
+	rootCmd.PersistentFlags().IntVar(&count, "count", 1, "run each benchmark n times, in separate processes, and export statistics across the runs along with their merged profile")
+	rootCmd.PersistentFlags().IntSliceVar(&cpus, "cpu", nil, "comma-separated list of GOMAXPROCS values to run every benchmark with, e.g. 1,2,4,8. Every value gets its own results and profiles, uploaded under the benchmark name suffixed by -<value>")
+	rootCmd.PersistentFlags().StringSliceVar(&profiles, "profiles", []string{"cpu", "mem"}, "comma-separated list of profiles to collect for every benchmark, out of "+strings.Join(profileNames(), ","))
+	rootCmd.PersistentFlags().IntVar(&blockProfileRate, "blockprofilerate", 1, "go test -blockprofilerate used when collecting the block profile")
+	rootCmd.PersistentFlags().IntVar(&mutexProfileFraction, "mutexprofilefraction", 1, "go test -mutexprofilefraction used when collecting the mutex profile")
+	rootCmd.PersistentFlags().DurationVar(&benchmarkTimeout, "benchmark-timeout", 0, "stop a benchmark, with all its runs, if it takes longer than this duration, e.g. 10m. 0 means no timeout")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 0, "stop the whole test command if it takes longer than this duration, e.g. 1h. 0 means no timeout")
+	rootCmd.PersistentFlags().StringVar(&outputDir, "output-dir", "codeperf-output", "directory under which every test run gets its own directory holding its profiles, reports, logs and manifest.json")
+	rootCmd.PersistentFlags().StringArrayVar(&goTestArgs, "go-test-arg", nil, "extra go test argument, e.g. --go-test-arg=-benchmem --go-test-arg=-timeout=30m. Repeat for every argument. Defaults to the "+goTestArgsConfigKey+" list of the config file")
+	rootCmd.PersistentFlags().StringArrayVar(&testEnv, "env", nil, "extra KEY=VALUE environment variable of the go commands and benchmarks, e.g. --env GOEXPERIMENT=loopvar. Repeat for every variable. Defaults to the "+testEnvConfigKey+" list of the config file. Values are recorded in the exported metadata")
+	rootCmd.PersistentFlags().BoolVar(&resume, "resume", false, "skip the benchmarks already completed for the same commit by a previous run of the output directory, provided their inputs are unchanged, their artifacts intact and their results uploaded. Completed benchmarks are recorded in "+stateFile+" of the output directory")
+	rootCmd.PersistentFlags().BoolVar(&strict, "strict", false, "abort before running any benchmark if the host looks noisy: high load average, cpufreq governor other than performance, turbo boost, cgroup CPU throttling or low available memory. These are otherwise reported as warnings")
+	rootCmd.PersistentFlags().StringVar(&cpuset, "cpuset", "", "pin the benchmark processes to the given CPUs, e.g. 2-5 or 0,2,4. Linux only")
+	rootCmd.PersistentFlags().IntVar(&nice, "nice", 0, "run the benchmark processes with the given nice value, from -20 (highest priority) to 19. Negative values require CAP_SYS_NICE. Linux only")
+	rootCmd.PersistentFlags().StringVar(&sched, "sched", "", "run the benchmark processes with the given scheduling policy, out of other, batch, idle, fifo and rr, optionally followed by its priority, e.g. fifo:10. fifo and rr require CAP_SYS_NICE. Linux only")
+	rootCmd.PersistentFlags().BoolVar(&trace, "trace", false, "record an execution trace of every benchmark run and export a summary of its GC pauses, goroutines, scheduler latency, syscalls and blocking waits. Requires go1.23 or later")
+	rootCmd.PersistentFlags().StringVar(&bench, "bench", "", "Benchmark name. On test, a go test -bench style regular expression selecting the benchmarks to run")
+	rootCmd.PersistentFlags().StringVar(&skipBench, "skip-bench", "", "go test -skip style regular expression selecting the benchmarks not to run")
+	rootCmd.PersistentFlags().StringSliceVar(&tags, "tags", []string{}, "comma-separated list of build tags to consider satisfied during benchmark discovery and runs")
+	rootCmd.PersistentFlags().BoolVar(&failOnParseErrors, "fail-on-parse-errors", false, "exit with a non-zero code if any file cannot be read or parsed during benchmark discovery")
+	rootCmd.PersistentFlags().StringVar(&since, "since", "", "only run the benchmarks of packages affected by the changes since the merge base with the given git revision, e.g. origin/main")
+	rootCmd.PersistentFlags().BoolVar(&subBenchmarks, "sub-benchmarks", true, "enumerate the sub-benchmarks created via b.Run and profile each of them separately")
 	//rootCmd.MarkPersistentFlagRequired("bench")
 }
 
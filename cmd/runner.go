@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// benchmarkTimeout is set by the --benchmark-timeout flag.
+var benchmarkTimeout time.Duration
+
+// benchmarkRun holds the outcome of running a benchmark.
+type benchmarkRun struct {
+	// Results holds the results of every run with their statistics.
+	Results BenchmarkResults
+	// Profiles holds the name of the file of every collected profile, by
+	// profile name, merging every run.
+	Profiles map[string]string
+	// Traces holds the runtime summary of the execution trace of every run,
+	// with --trace.
+	Traces []TraceSummary
+	// Commands holds the command line of every run.
+	Commands []string
+}
+
+// runBenchmark runs the benchmark count times, as set by --count or the
+// count directive, each run in its own process of the given test binary.
+// Profiles, traces and the output of every run are written to dir. Runs are
+// stopped once ctx is done or after --benchmark-timeout. On failure the
+// profiles and traces written so far are removed.
+func runBenchmark(ctx context.Context, goPath string, binary string, dir string, benchmark Benchmark) (result benchmarkRun, err error) {
+	ctx, cancel := withOptionalTimeout(ctx, benchmarkTimeout)
+	defer cancel()
+	var artifacts []string
+	defer func() {
+		if err != nil {
+			for _, artifact := range artifacts {
+				os.Remove(artifact)
+			}
+		}
+	}()
+
+	kinds := benchmarkProfiles(benchmark)
+	result.Profiles = map[string]string{}
+	for _, kind := range kinds {
+		name := filepath.Join(dir, kind.fileName(benchmark))
+		result.Profiles[kind.Name] = name
+		artifacts = append(artifacts, name)
+	}
+	benchmarkBenchtime := benchtime
+	if benchmark.Directives.Benchtime != "" {
+		benchmarkBenchtime = benchmark.Directives.Benchtime
+	}
+	runs := count
+	if benchmark.Directives.Count > 0 {
+		runs = benchmark.Directives.Count
+	}
+	runProfiles := map[string][]string{}
+	for run := 1; run <= runs; run++ {
+		c := command{
+			Name: binary,
+			Args: []string{"-test.run=^$", "-test.bench=" + benchPattern(benchmark), "-test.benchtime=" + benchmarkBenchtime},
+			// Like go test, run the test binary from the package directory.
+			Dir:       filepath.FromSlash(benchmark.Dir),
+			Env:       commandEnv(),
+			Stream:    true,
+			Isolation: isolation,
+		}
+		if benchmark.Procs > 0 {
+			c.Args = append(c.Args, fmt.Sprintf("-test.cpu=%d", benchmark.Procs))
+		}
+		c.Args = append(c.Args, passThrough.Run...)
+		for _, kind := range kinds {
+			runProfileName := result.Profiles[kind.Name]
+			if runs > 1 {
+				runProfileName = fmt.Sprintf("%s.%d", runProfileName, run)
+				artifacts = append(artifacts, runProfileName)
+			}
+			runProfiles[kind.Name] = append(runProfiles[kind.Name], runProfileName)
+			c.Args = append(c.Args, kind.runArgs(runProfileName)...)
+		}
+		traceName := ""
+		if trace {
+			traceName = filepath.Join(dir, traceFileName(benchmark, run, runs))
+			artifacts = append(artifacts, traceName)
+			c.Args = append(c.Args, "-test.trace", traceName)
+		}
+		log.Println(fmt.Sprintf("Running benchmark %s (%s) from %s, run %d of %d, with the following command: %s.", benchmark.RunName(), benchmark.ImportPath, benchmark.Dir, run, runs, c))
+		result.Commands = append(result.Commands, c.String())
+		var cpuTime time.Duration
+		c.CPUTime = &cpuTime
+		sampler := startNoiseSampler()
+		out, err := c.run(ctx)
+		noise := sampler.finish(cpuTime)
+		if logErr := os.WriteFile(filepath.Join(dir, fmt.Sprintf("run-%d.log", run)), out, 0644); logErr != nil {
+			log.Printf("Unable to save the output of %s. Error: %v", benchmark.RunName(), logErr)
+		}
+		if err != nil {
+			return result, fmt.Errorf("benchmark %s failed: %v", benchmark.RunName(), commandError(c, err, out))
+		}
+		if err = verifyBenchmarkRan(out, benchmark); err != nil {
+			return result, err
+		}
+		result.Results.Runs = append(result.Results.Runs, benchmarkResults(out, benchmark)...)
+		result.Results.Noise = append(result.Results.Noise, noise)
+		result.Results.NoiseScore = math.Max(result.Results.NoiseScore, noise.Score)
+		if noise.Score > noisyRunScore {
+			log.Printf("%s: run %d was noisy, with a noise score of %.2f: %.0f%% of the CPU time used by other processes, %.0f%% stolen, %.0f%% of the cgroup periods throttled, load average up to %.2f.", benchmark.RunName(), run, noise.Score, 100*noise.ForeignCPU, 100*noise.Steal, 100*noise.Throttled, noise.LoadAvg)
+		}
+		if traceName != "" {
+			summary, err := summarizeTrace(ctx, goPath, traceName)
+			if err != nil {
+				return result, fmt.Errorf("unable to summarize the execution trace of %s: %v", benchmark.RunName(), err)
+			}
+			log.Printf("%s: trace of run %d: %d GC cycles, %d goroutines created, max %d live.", benchmark.RunName(), run, summary.GCCycles, summary.GoroutinesCreated, summary.MaxGoroutines)
+			result.Traces = append(result.Traces, summary)
+		}
+	}
+	result.Results.Summary = summarize(result.Results.Runs)
+	for _, summary := range result.Results.Summary {
+		log.Printf("%s%s: %s", benchmark.RunName(), strings.TrimPrefix(summary.Name, benchmark.FullName()), summary)
+	}
+	if runs > 1 {
+		for _, kind := range kinds {
+			if err := mergeProfiles(runProfiles[kind.Name], result.Profiles[kind.Name]); err != nil {
+				return result, fmt.Errorf("unable to merge the %s profiles of %s: %v", kind.Name, benchmark.RunName(), err)
+			}
+			for _, runProfile := range runProfiles[kind.Name] {
+				os.Remove(runProfile)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// MetricSummary holds the statistics of one metric across repeated runs of a
+// benchmark.
+type MetricSummary struct {
+	// Name is the benchmark the metric was reported by: the benchmark itself,
+	// or one of its sub-benchmarks when it was run as a whole.
+	Name   string  `json:"name"`
+	Unit   string  `json:"unit"`
+	N      int     `json:"n"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	StdDev float64 `json:"stddev"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	// CILow and CIHigh bound the 95% confidence interval of the mean, using
+	// Student's t-distribution. Both equal the mean when N < 2.
+	CILow  float64 `json:"ciLow"`
+	CIHigh float64 `json:"ciHigh"`
+}
+
+// String formats the summary benchstat-style, e.g. "1.04k ns/op ± 2%".
+func (s MetricSummary) String() string {
+	spread := 0.0
+	if s.Mean != 0 {
+		spread = 100 * (s.CIHigh - s.Mean) / math.Abs(s.Mean)
+	}
+	return fmt.Sprintf("%.4g %s ± %.0f%% (median %.4g, min %.4g, max %.4g, n=%d)", s.Mean, s.Unit, spread, s.Median, s.Min, s.Max, s.N)
+}
+
+// BenchmarkResults holds every run of a benchmark along with per metric
+// statistics across the runs, and the host noise sampled during every run.
+type BenchmarkResults struct {
+	Runs    []BenchmarkResult `json:"runs"`
+	Summary []MetricSummary   `json:"summary"`
+	Noise   []NoiseSample     `json:"noise"`
+	// NoiseScore is the highest noise score across the runs.
+	NoiseScore float64 `json:"noiseScore"`
+}
+
+// studentT975 holds the 0.975 quantiles of Student's t-distribution for 1 to
+// 30 degrees of freedom. Larger samples use the normal approximation.
+var studentT975 = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+func summarizeValues(unit string, values []float64) (s MetricSummary) {
+	s.Unit = unit
+	s.N = len(values)
+	if s.N == 0 {
+		return
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	s.Min, s.Max = sorted[0], sorted[s.N-1]
+	if s.N%2 == 1 {
+		s.Median = sorted[s.N/2]
+	} else {
+		s.Median = (sorted[s.N/2-1] + sorted[s.N/2]) / 2
+	}
+	for _, v := range values {
+		s.Mean += v
+	}
+	s.Mean /= float64(s.N)
+	s.CILow, s.CIHigh = s.Mean, s.Mean
+	if s.N < 2 {
+		return
+	}
+	for _, v := range values {
+		s.StdDev += (v - s.Mean) * (v - s.Mean)
+	}
+	s.StdDev = math.Sqrt(s.StdDev / float64(s.N-1))
+	t := 1.96
+	if s.N-1 <= len(studentT975) {
+		t = studentT975[s.N-2]
+	}
+	margin := t * s.StdDev / math.Sqrt(float64(s.N))
+	s.CILow, s.CIHigh = s.Mean-margin, s.Mean+margin
+	return
+}
+
+// summarize computes the statistics of every metric reported by the runs,
+// in order of first appearance.
+func summarize(runs []BenchmarkResult) (summary []MetricSummary) {
+	type key struct{ name, unit string }
+	var keys []key
+	values := map[key][]float64{}
+	for _, run := range runs {
+		for _, m := range run.Metrics {
+			k := key{run.Name, m.Unit}
+			if _, ok := values[k]; !ok {
+				keys = append(keys, k)
+			}
+			values[k] = append(values[k], m.Value)
+		}
+	}
+	for _, k := range keys {
+		s := summarizeValues(k.unit, values[k])
+		s.Name = k.name
+		summary = append(summary, s)
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_summarizeValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   MetricSummary
+	}{
+		{"empty", nil, MetricSummary{Unit: "ns/op"}},
+		{"single", []float64{10}, MetricSummary{Unit: "ns/op", N: 1, Mean: 10, Median: 10, Min: 10, Max: 10, CILow: 10, CIHigh: 10}},
+		// stddev = 1, margin = 4.303 * 1 / sqrt(3)
+		{"odd", []float64{11, 9, 10}, MetricSummary{Unit: "ns/op", N: 3, Mean: 10, Median: 10, StdDev: 1, Min: 9, Max: 11, CILow: 10 - 4.303/math.Sqrt(3), CIHigh: 10 + 4.303/math.Sqrt(3)}},
+		{"even", []float64{4, 1, 3, 2}, MetricSummary{Unit: "ns/op", N: 4, Mean: 2.5, Median: 2.5, StdDev: math.Sqrt(5.0 / 3), Min: 1, Max: 4, CILow: 2.5 - 3.182*math.Sqrt(5.0/3)/2, CIHigh: 2.5 + 3.182*math.Sqrt(5.0/3)/2}},
+	}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := summarizeValues("ns/op", tt.values)
+			if got.Unit != tt.want.Unit || got.N != tt.want.N || !near(got.Mean, tt.want.Mean) || !near(got.Median, tt.want.Median) ||
+				!near(got.StdDev, tt.want.StdDev) || !near(got.Min, tt.want.Min) || !near(got.Max, tt.want.Max) ||
+				!near(got.CILow, tt.want.CILow) || !near(got.CIHigh, tt.want.CIHigh) {
+				t.Errorf("summarizeValues() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_summarize(t *testing.T) {
+	runs := []BenchmarkResult{
+		{Name: "BenchmarkGet", Metrics: []Metric{{100, "ns/op"}, {64, "B/op"}}},
+		{Name: "BenchmarkGet", Metrics: []Metric{{120, "ns/op"}, {64, "B/op"}}},
+	}
+	got := summarize(runs)
+	if len(got) != 2 || got[0].Unit != "ns/op" || got[0].Mean != 110 || got[1].Unit != "B/op" || got[1].StdDev != 0 {
+		t.Errorf("summarize() = %+v", got)
+	}
+	subs := []BenchmarkResult{
+		{Name: "BenchmarkGet/small", Metrics: []Metric{{10, "ns/op"}}},
+		{Name: "BenchmarkGet/large", Metrics: []Metric{{1000, "ns/op"}}},
+		{Name: "BenchmarkGet/small", Metrics: []Metric{{20, "ns/op"}}},
+	}
+	got = summarize(subs)
+	if len(got) != 2 || got[0].Name != "BenchmarkGet/small" || got[0].Mean != 15 || got[1].Name != "BenchmarkGet/large" || got[1].N != 1 {
+		t.Errorf("summarize() = %+v, want one summary per sub-benchmark", got)
+	}
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// benchLineRegExp matches a benchmark result line of go test output and
+// captures the full benchmark name (including sub-benchmark elements).
+var benchLineRegExp = regexp.MustCompile(`^(Benchmark\S*)\s+\d+\s+`)
+
+// parseBenchmarkNames returns the names of the benchmarks which reported
+// results in the given go test output, in order of appearance.
+// Names keep the GOMAXPROCS suffix unless the output was produced with -cpu=1.
+func parseBenchmarkNames(output []byte) (names []string) {
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		matches := benchLineRegExp.FindStringSubmatch(scanner.Text())
+		if len(matches) < 2 || seen[matches[1]] {
+			continue
+		}
+		seen[matches[1]] = true
+		names = append(names, matches[1])
+	}
+	return
+}
+
+// expandSubBenchmarks replaces every benchmark which calls b.Run by one entry
+// per leaf sub-benchmark. Sub-benchmarks are enumerated by a single iteration
+// dry run of every benchmark on its own, so that a failing benchmark doesn't
+// prevent the enumeration of the others. Benchmarks whose dry run fails are
+// kept as is.
+func expandSubBenchmarks(ctx context.Context, goPath string, tags []string, benchmarks []Benchmark) (expanded []Benchmark) {
+	for _, benchmark := range benchmarks {
+		binary, err := testBinary(ctx, goPath, tags, benchmark)
+		if err != nil {
+			log.Printf("Unable to enumerate sub-benchmarks of %s, profiling it as a whole. Error: %v", benchmark.Name, err)
+			expanded = append(expanded, benchmark)
+			continue
+		}
+		subs, err := listSubBenchmarks(ctx, binary, benchmark)
+		if err != nil {
+			log.Printf("Unable to enumerate sub-benchmarks of %s, profiling it as a whole. Error: %v", benchmark.Name, err)
+			expanded = append(expanded, benchmark)
+			continue
+		}
+		if len(subs) == 0 {
+			expanded = append(expanded, benchmark)
+			continue
+		}
+		for _, sub := range subs {
+			subBenchmark := benchmark
+			subBenchmark.Sub = sub
+			expanded = append(expanded, subBenchmark)
+		}
+		log.Println(fmt.Sprintf("Detected %d sub-benchmarks of %s.", len(subs), benchmark.Name))
+	}
+	return
+}
+
+// listSubBenchmarks returns the leaf sub-benchmarks of the benchmark, from a
+// single iteration dry run of it alone.
+func listSubBenchmarks(ctx context.Context, binary string, benchmark Benchmark) (subs []string, err error) {
+	c := command{
+		Name: binary,
+		Args: []string{"-test.run=^$", "-test.bench=" + benchPattern(benchmark), "-test.benchtime=1x", "-test.cpu=1"},
+		Dir:  filepath.FromSlash(benchmark.Dir),
+		Env:  commandEnv(),
+	}
+	log.Printf("Enumerating sub-benchmarks of %s (%s) with the following command: %s.", benchmark.Name, benchmark.ImportPath, c)
+	out, err := c.run(ctx)
+	if err != nil {
+		return nil, commandError(c, err, out)
+	}
+	for _, name := range parseBenchmarkNames(out) {
+		if parts := strings.SplitN(name, "/", 2); len(parts) == 2 && parts[0] == benchmark.Name {
+			subs = append(subs, parts[1])
+		}
+	}
+	return
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseBenchmarkNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{"empty", "PASS\nok  \texample.com/mono\t0.010s\n", nil},
+		{"top-level", `goos: linux
+goarch: amd64
+pkg: example.com/mono
+BenchmarkGet    	       1	      1234 ns/op
+BenchmarkGetAll 	       1	      4321 ns/op
+PASS
+`, []string{"BenchmarkGet", "BenchmarkGetAll"}},
+		{"sub-benchmarks", `BenchmarkTable/small         	       1	       100 ns/op
+BenchmarkTable/large_input   	       1	     10000 ns/op
+BenchmarkTable/nested/a#01   	       1	       200 ns/op
+BenchmarkTable/small         	       1	       100 ns/op
+`, []string{"BenchmarkTable/small", "BenchmarkTable/large_input", "BenchmarkTable/nested/a#01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseBenchmarkNames([]byte(tt.output)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBenchmarkNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// benchmarksFailedExitCode is the exit code of the test command when any
+// benchmark failed, distinct from the exit code 1 of other errors.
+const benchmarksFailedExitCode = 3
+
+// maxErrorExcerpt is the maximum length of the error excerpts of the run
+// summary.
+const maxErrorExcerpt = 100
+
+// Benchmark statuses of the run summary.
+const (
+	statusPassed  = "passed"
+	statusFailed  = "failed"
+	statusSkipped = "skipped"
+	// statusCached is the status of the benchmarks skipped by --resume.
+	statusCached = "cached"
+)
+
+// benchmarkOutcome holds the outcome of one benchmark of the test command.
+type benchmarkOutcome struct {
+	Benchmark Benchmark
+	Status    string
+	Duration  time.Duration
+	// Err is the failure or skip reason, nil for passed benchmarks.
+	Err error
+	// InputsHash identifies the inputs of the benchmark, once known.
+	InputsHash string
+	// Cached is the previous completion reused by --resume.
+	Cached *completedBenchmark
+}
+
+// runSummary collects the outcome of every benchmark of the test command.
+type runSummary struct {
+	Outcomes []benchmarkOutcome
+}
+
+func (s *runSummary) add(o benchmarkOutcome) {
+	s.Outcomes = append(s.Outcomes, o)
+}
+
+// count returns the number of benchmarks with the given status.
+func (s runSummary) count(status string) (n int) {
+	for _, o := range s.Outcomes {
+		if o.Status == status {
+			n++
+		}
+	}
+	return
+}
+
+// print writes the table of the benchmark outcomes, followed by the totals.
+func (s runSummary) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tBENCHMARK\tPACKAGE\tDURATION\tERROR")
+	for _, o := range s.Outcomes {
+		excerpt := ""
+		if o.Err != nil {
+			excerpt = errorExcerpt(o.Err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", o.Status, o.Benchmark.RunName(), o.Benchmark.ImportPath, o.Duration.Round(time.Millisecond), excerpt)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d passed, %d cached, %d failed, %d skipped.\n", s.count(statusPassed), s.count(statusCached), s.count(statusFailed), s.count(statusSkipped))
+}
+
+// errorExcerptPattern matches the lines most telling of why a benchmark
+// failed: panics, runtime errors, test failures and compilation errors.
+var errorExcerptPattern = regexp.MustCompile(`^(panic: |fatal error: |--- FAIL: |\s+\S+_test\.go:\d+: |\S+\.go:\d+:\d+: )`)
+
+// errorExcerpt returns the most telling line of a benchmark error: the first
+// panic or failure line of its output, else its first line, shortened to
+// maxErrorExcerpt.
+func errorExcerpt(err error) string {
+	lines := strings.Split(err.Error(), "\n")
+	excerpt := lines[0]
+	for _, line := range lines[1:] {
+		if errorExcerptPattern.MatchString(line) {
+			excerpt = strings.TrimSpace(line)
+			break
+		}
+	}
+	if runes := []rune(excerpt); len(runes) > maxErrorExcerpt {
+		excerpt = string(runes[:maxErrorExcerpt-3]) + "..."
+	}
+	return excerpt
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_errorExcerpt(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"single line", errors.New("timed out"), "timed out"},
+		{"panic", errors.New("benchmark BenchmarkGet failed: store.test failed: exit status 2\ngoos: linux\npanic: runtime error: index out of range [3] with length 3\n\ngoroutine 1 [running]:"), "panic: runtime error: index out of range [3] with length 3"},
+		{"test failure", errors.New("benchmark BenchmarkGet failed\n--- FAIL: BenchmarkGet\n    store_test.go:12: unexpected value\n"), "--- FAIL: BenchmarkGet"},
+		{"compilation error", errors.New("go test -c failed: exit status 1\n# example.com/store\n./store_test.go:12:3: undefined: Get\n"), "./store_test.go:12:3: undefined: Get"},
+		{"long", errors.New(strings.Repeat("x", 150)), strings.Repeat("x", 97) + "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorExcerpt(tt.err); got != tt.want {
+				t.Errorf("errorExcerpt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_runSummary_print(t *testing.T) {
+	var s runSummary
+	s.add(benchmarkOutcome{Benchmark: Benchmark{Name: "BenchmarkGet", ImportPath: "example.com/store"}, Status: statusPassed, Duration: 1500 * time.Millisecond})
+	s.add(benchmarkOutcome{Benchmark: Benchmark{Name: "BenchmarkPut", ImportPath: "example.com/store"}, Status: statusFailed, Duration: time.Second, Err: errors.New("failed\npanic: boom")})
+	s.add(benchmarkOutcome{Benchmark: Benchmark{Name: "BenchmarkDel", ImportPath: "example.com/store"}, Status: statusSkipped, Err: errors.New("not run, the test command was interrupted")})
+	var out strings.Builder
+	s.print(&out)
+	want := "STATUS   BENCHMARK     PACKAGE            DURATION  ERROR\n" +
+		"passed   BenchmarkGet  example.com/store  1.5s      \n" +
+		"failed   BenchmarkPut  example.com/store  1s        panic: boom\n" +
+		"skipped  BenchmarkDel  example.com/store  0s        not run, the test command was interrupted\n" +
+		"1 passed, 0 cached, 1 failed, 1 skipped.\n"
+	if out.String() != want {
+		t.Errorf("print() =\n%s\nwant\n%s", out.String(), want)
+	}
+}
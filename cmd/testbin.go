@@ -0,0 +1,291 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// testInputPackage holds the go list -json fields identifying the inputs of
+// a package compiled into a test binary.
+type testInputPackage struct {
+	ImportPath      string
+	Dir             string
+	Standard        bool
+	Module          *testInputModule
+	GoFiles         []string
+	CgoFiles        []string
+	CFiles          []string
+	CXXFiles        []string
+	HFiles          []string
+	SFiles          []string
+	SysoFiles       []string
+	EmbedFiles      []string
+	TestGoFiles     []string
+	XTestGoFiles    []string
+	TestEmbedFiles  []string
+	XTestEmbedFiles []string
+}
+
+// testInputModule holds the go list -json fields of the module of a package.
+type testInputModule struct {
+	Path    string
+	Version string
+	GoMod   string
+	// Replace is the module replacing this one, if any: a directory when its
+	// Version is empty.
+	Replace *testInputModule
+}
+
+// testBinaries caches the test binary of every package already built during
+// this run, by import path.
+var testBinaries = map[string]string{}
+
+// testBinaryErrors holds the failed test binary builds of this run, by import
+// path, so that the benchmarks of a package which does not compile don't
+// build it again.
+var testBinaryErrors = map[string]error{}
+
+// testBinaryMaxAge is how long a cached test binary is kept without being
+// used. Older ones are removed by the first build of a run.
+const testBinaryMaxAge = 30 * 24 * time.Hour
+
+// testBinaryCacheEvicted is set once the test binary cache was evicted during
+// this run.
+var testBinaryCacheEvicted bool
+
+// testBinaryCacheDir returns the directory test binaries are cached in across
+// runs.
+func testBinaryCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "codeperf", "testbin"), nil
+}
+
+// hashTestInputs writes the content of every input of the given packages to
+// h. Standard library packages are identified by the go version, and
+// packages of versioned module dependencies by their module version. The
+// packages of the main modules and of modules replaced by a directory are
+// hashed file by file, along with the go.mod and go.sum of their module.
+func hashTestInputs(h io.Writer, pkgs []testInputPackage) error {
+	modules := map[string]bool{}
+	for _, pkg := range pkgs {
+		if pkg.Standard {
+			continue
+		}
+		if m := pkg.Module; m != nil {
+			if m.Replace != nil {
+				m = m.Replace
+			}
+			if m.Version != "" {
+				fmt.Fprintf(h, "package %s %s@%s\n", pkg.ImportPath, m.Path, m.Version)
+				continue
+			}
+			if m.GoMod != "" && !modules[m.GoMod] {
+				modules[m.GoMod] = true
+				if err := hashFiles(h, m.GoMod, filepath.Join(filepath.Dir(m.GoMod), "go.sum")); err != nil {
+					return err
+				}
+			}
+		}
+		fmt.Fprintf(h, "package %s %s\n", pkg.ImportPath, pkg.Dir)
+		for _, files := range [][]string{pkg.GoFiles, pkg.CgoFiles, pkg.CFiles, pkg.CXXFiles, pkg.HFiles, pkg.SFiles, pkg.SysoFiles,
+			pkg.EmbedFiles, pkg.TestGoFiles, pkg.XTestGoFiles, pkg.TestEmbedFiles, pkg.XTestEmbedFiles} {
+			for _, file := range files {
+				if filepath.IsAbs(file) {
+					// Generated in the build cache from the other inputs,
+					// e.g. the test main.
+					continue
+				}
+				content, err := os.ReadFile(filepath.Join(pkg.Dir, file))
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(h, "file %s %d\n", file, len(content))
+				h.Write(content)
+			}
+		}
+	}
+	return nil
+}
+
+// hashFiles writes the content of the given module files to h, skipping
+// those which do not exist, e.g. the go.sum of a module without
+// dependencies.
+func hashFiles(h io.Writer, names ...string) error {
+	for _, name := range names {
+		content, err := os.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "file %s %d\n", name, len(content))
+		h.Write(content)
+	}
+	return nil
+}
+
+// evictTestBinaries removes the test binaries of the cache directory which
+// were not used for testBinaryMaxAge, along with leftovers of interrupted
+// builds. A cached binary is touched whenever it is reused.
+func evictTestBinaries(cacheDir string, now time.Time) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < testBinaryMaxAge {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(cacheDir, entry.Name())); err != nil {
+			log.Printf("Unable to evict %s from the test binary cache: %v", entry.Name(), err)
+		}
+	}
+}
+
+// testBinaryHash returns the content hash of the inputs of the test binary of
+// the benchmark package: the go toolchain and target, the environment, the
+// build tags and flags, the go.work file and the sources of the package and
+// its dependencies.
+func testBinaryHash(goPath string, tags []string, b Benchmark) (string, error) {
+	h := sha256.New()
+	for _, args := range [][]string{{"version"}, {"env", "GOOS", "GOARCH", "CGO_ENABLED", "GOFLAGS", "GOEXPERIMENT", "GOWORK"}} {
+		c := exec.Command(goPath, args...)
+		c.Dir = filepath.FromSlash(b.ModuleDir)
+		c.Env = commandEnv()
+		out, err := c.Output()
+		if err != nil {
+			return "", fmt.Errorf("go %s failed: %v", strings.Join(args, " "), err)
+		}
+		h.Write(out)
+		if args[0] == "env" {
+			lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+			if work := lines[len(lines)-1]; filepath.IsAbs(work) {
+				if err := hashFiles(h, work, work+".sum"); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+	fmt.Fprintf(h, "env %q\n", testEnv)
+	fmt.Fprintf(h, "tags %s\n", strings.Join(tags, ","))
+	fmt.Fprintf(h, "flags %q\n", passThrough.Build)
+	args := []string{"list", "-deps", "-test", "-json"}
+	if len(tags) > 0 {
+		args = append(args, "-tags="+strings.Join(tags, ","))
+	}
+	args = append(args, passThrough.Build...)
+	args = append(args, b.ModulePackage())
+	c := exec.Command(goPath, args...)
+	c.Dir = filepath.FromSlash(b.ModuleDir)
+	c.Env = commandEnv()
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("go list failed for %s: %v", b.ImportPath, err)
+	}
+	var pkgs []testInputPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var pkg testInputPackage
+		if err := dec.Decode(&pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	if err := hashTestInputs(h, pkgs); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// testBinary returns the path of the test binary of the benchmark package,
+// building it with go test -c unless a binary built from the same inputs is
+// already cached. A failed build is returned again for the other benchmarks
+// of the package.
+func testBinary(ctx context.Context, goPath string, tags []string, b Benchmark) (string, error) {
+	if binary, ok := testBinaries[b.ImportPath]; ok {
+		return binary, nil
+	}
+	if err, ok := testBinaryErrors[b.ImportPath]; ok {
+		return "", err
+	}
+	binary, err := buildTestBinary(ctx, goPath, tags, b)
+	if err != nil {
+		// Builds interrupted by --timeout or a signal are not failures of
+		// the package.
+		if ctx.Err() == nil {
+			testBinaryErrors[b.ImportPath] = err
+		}
+		return "", err
+	}
+	testBinaries[b.ImportPath] = binary
+	return binary, nil
+}
+
+// buildTestBinary returns the path of the test binary of the benchmark
+// package, from the cache or built.
+func buildTestBinary(ctx context.Context, goPath string, tags []string, b Benchmark) (string, error) {
+	hash, err := testBinaryHash(goPath, tags, b)
+	if err != nil {
+		return "", err
+	}
+	cacheDir, err := testBinaryCacheDir()
+	if err != nil {
+		return "", err
+	}
+	if !testBinaryCacheEvicted {
+		testBinaryCacheEvicted = true
+		evictTestBinaries(cacheDir, time.Now())
+	}
+	binary := filepath.Join(cacheDir, hash+".test")
+	if _, err := os.Stat(binary); err == nil {
+		log.Printf("Reusing the cached test binary of %s: %s.", b.ImportPath, binary)
+		now := time.Now()
+		if err := os.Chtimes(binary, now, now); err != nil {
+			return "", err
+		}
+		return binary, nil
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
+	// Build next to the final path and rename so that concurrent runs never
+	// see a partially written binary.
+	tmpDir, err := os.MkdirTemp(cacheDir, "build")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	tmp := filepath.Join(tmpDir, filepath.Base(binary))
+	c := command{Name: goPath, Args: []string{"test", "-c", "-o", tmp}, Dir: filepath.FromSlash(b.ModuleDir), Env: commandEnv()}
+	if len(tags) > 0 {
+		c.Args = append(c.Args, "-tags="+strings.Join(tags, ","))
+	}
+	c.Args = append(c.Args, passThrough.Build...)
+	c.Args = append(c.Args, b.ModulePackage())
+	log.Printf("Building the test binary of %s with the following command: %s.", b.ImportPath, c)
+	if out, err := c.run(ctx); err != nil {
+		return "", fmt.Errorf("unable to build the test binary of %s: %v", b.ImportPath, commandError(c, err, out))
+	}
+	if err := os.Rename(tmp, binary); err != nil {
+		return "", err
+	}
+	return binary, nil
+}
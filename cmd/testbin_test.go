@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_hashTestInputs(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod":     "module example.com/a\n",
+		"a.go":       "package a\n",
+		"a_test.go":  "package a\n\nfunc BenchmarkA(b *testing.B) {}\n",
+		"dep/go.mod": "module example.com/dep\n",
+		"dep/dep.go": "package dep\n",
+	})
+	local := testInputPackage{ImportPath: "example.com/a", Dir: root, GoFiles: []string{"a.go"}, TestGoFiles: []string{"a_test.go"}}
+	local.Module = &testInputModule{Path: "example.com/a", GoMod: filepath.Join(root, "go.mod")}
+	dependency := testInputPackage{ImportPath: "example.com/dep", Dir: filepath.Join(root, "missing")}
+	dependency.Module = &testInputModule{Path: "example.com/dep", Version: "v1.2.3"}
+	standard := testInputPackage{ImportPath: "fmt", Dir: filepath.Join(root, "missing"), Standard: true, GoFiles: []string{"print.go"}}
+
+	hash := func(pkgs ...testInputPackage) string {
+		t.Helper()
+		h := sha256.New()
+		if err := hashTestInputs(h, pkgs); err != nil {
+			t.Fatalf("hashTestInputs() error = %v", err)
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	before := hash(local, dependency, standard)
+	if again := hash(local, dependency, standard); again != before {
+		t.Errorf("hashTestInputs() is not deterministic: %s != %s", again, before)
+	}
+	if withoutStandard := hash(local, dependency); withoutStandard != before {
+		t.Errorf("hashTestInputs() depends on standard packages")
+	}
+	if err := os.WriteFile(filepath.Join(root, "a_test.go"), []byte("package a\n\nfunc BenchmarkB(b *testing.B) {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if after := hash(local, dependency, standard); after == before {
+		t.Errorf("hashTestInputs() did not change after a test file changed")
+	}
+	upgraded := dependency
+	upgraded.Module = &testInputModule{Path: "example.com/dep", Version: "v1.2.4"}
+	if hash(upgraded) == hash(dependency) {
+		t.Errorf("hashTestInputs() did not change after a dependency upgrade")
+	}
+	before = hash(local)
+	if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/a\n\nrequire example.com/dep v1.2.4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if hash(local) == before {
+		t.Errorf("hashTestInputs() did not change after go.mod changed")
+	}
+	before = hash(local)
+	writeFiles(t, root, map[string]string{"go.sum": "example.com/dep v1.2.4 h1:abc=\n"})
+	if hash(local) == before {
+		t.Errorf("hashTestInputs() did not change after go.sum changed")
+	}
+
+	replaced := testInputPackage{ImportPath: "example.com/dep", Dir: filepath.Join(root, "dep"), GoFiles: []string{"dep.go"}}
+	replaced.Module = &testInputModule{Path: "example.com/dep", Version: "v1.2.3",
+		Replace: &testInputModule{Path: "./dep", GoMod: filepath.Join(root, "dep", "go.mod")}}
+	before = hash(replaced)
+	if err := os.WriteFile(filepath.Join(root, "dep", "dep.go"), []byte("package dep\n\nconst V = 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if hash(replaced) == before {
+		t.Errorf("hashTestInputs() did not change after a file of a dependency replaced by a directory changed")
+	}
+	forked := dependency
+	forked.Module = &testInputModule{Path: "example.com/dep", Version: "v1.2.3", Replace: &testInputModule{Path: "example.com/fork", Version: "v1.2.3"}}
+	if hash(forked) == hash(dependency) {
+		t.Errorf("hashTestInputs() ignored the replacement of a dependency by another module version")
+	}
+	local.GoFiles = append(local.GoFiles, "missing.go")
+	h := sha256.New()
+	if err := hashTestInputs(h, []testInputPackage{local}); err == nil {
+		t.Errorf("hashTestInputs() expected an error for a missing file")
+	}
+}
+
+func Test_evictTestBinaries(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"recent.test": "bin", "stale.test": "bin", "build123/stale.test": "bin"})
+	now := time.Now()
+	old := now.Add(-testBinaryMaxAge - time.Hour)
+	for _, name := range []string{"stale.test", "build123"} {
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	evictTestBinaries(dir, now)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "recent.test" {
+		t.Errorf("evictTestBinaries() left %v, want only recent.test", entries)
+	}
+}
+
+func Test_testBinary_buildError(t *testing.T) {
+	goPath, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not installed")
+	}
+	// Keep the go build cache while moving the test binary cache.
+	gocache, err := exec.Command(goPath, "env", "GOCACHE").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOCACHE", strings.TrimSpace(string(gocache)))
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod":         "module example.com/broken\n\ngo 1.21\n",
+		"broken.go":      "package broken\n\nfunc Get() int { return undefined }\n",
+		"broken_test.go": "package broken\n\nimport \"testing\"\n\nfunc BenchmarkGet(b *testing.B) {}\n",
+	})
+	b := Benchmark{Name: "BenchmarkGet", Dir: ".", ImportPath: "example.com/broken-" + filepath.Base(root), ModuleDir: root}
+	defer delete(testBinaryErrors, b.ImportPath)
+	_, first := testBinary(context.Background(), goPath, nil, b)
+	if first == nil {
+		t.Fatal("testBinary() expected a build error")
+	}
+	// The module is fixed, but the failure of this run is remembered
+	// rather than built again.
+	writeFiles(t, root, map[string]string{"broken.go": "package broken\n\nfunc Get() int { return 1 }\n"})
+	if _, err := testBinary(context.Background(), goPath, nil, b); err != first {
+		t.Errorf("testBinary() = %v, want the first build error", err)
+	}
+}
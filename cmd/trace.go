@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DurationStats summarizes a set of durations, in nanoseconds.
+type DurationStats struct {
+	Count   int   `json:"count"`
+	TotalNs int64 `json:"totalNs"`
+	P50Ns   int64 `json:"p50Ns"`
+	P90Ns   int64 `json:"p90Ns"`
+	P99Ns   int64 `json:"p99Ns"`
+	MaxNs   int64 `json:"maxNs"`
+}
+
+func newDurationStats(durations []int64) (s DurationStats) {
+	s.Count = len(durations)
+	if s.Count == 0 {
+		return
+	}
+	sorted := append([]int64(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, d := range sorted {
+		s.TotalNs += d
+	}
+	quantile := func(q float64) int64 {
+		return sorted[int(q*float64(s.Count-1)+0.5)]
+	}
+	s.P50Ns, s.P90Ns, s.P99Ns, s.MaxNs = quantile(0.5), quantile(0.9), quantile(0.99), sorted[s.Count-1]
+	return
+}
+
+// TraceSummary holds the runtime behaviour derived from the execution trace
+// of a benchmark run.
+type TraceSummary struct {
+	// DurationNs is the time covered by the trace.
+	DurationNs int64 `json:"durationNs"`
+	// GCCycles is the number of GC mark phases started during the trace.
+	GCCycles int `json:"gcCycles"`
+	// GCPauses holds the stop-the-world pauses caused by the GC.
+	GCPauses DurationStats `json:"gcPauses"`
+	// GoroutinesCreated and MaxGoroutines count the goroutines created during
+	// the trace and the maximum number of goroutines alive at once.
+	GoroutinesCreated int `json:"goroutinesCreated"`
+	MaxGoroutines     int `json:"maxGoroutines"`
+	// SchedLatency holds the time goroutines spent runnable before running.
+	SchedLatency DurationStats `json:"schedLatency"`
+	// Syscalls holds the time goroutines spent in system calls.
+	Syscalls DurationStats `json:"syscalls"`
+	// NetworkWaits holds the time goroutines spent blocked on the network.
+	NetworkWaits DurationStats `json:"networkWaits"`
+	// WaitsNs holds the total time goroutines spent blocked, by wait reason
+	// (e.g. "chan receive", "sync", "select", "sleep").
+	WaitsNs map[string]int64 `json:"waitsNs"`
+}
+
+var (
+	traceEventRegExp      = regexp.MustCompile(`^M=\S+ P=\S+ G=\S+ (\w+) Time=(\d+)(?: (.*))?$`)
+	traceTransitionRegExp = regexp.MustCompile(`^GoID=(\d+) (\w+)->(\w+) Reason="(.*)"`)
+	traceRangeRegExp      = regexp.MustCompile(`^Name="([^"]*)" Scope=(\S+)`)
+)
+
+type goroutineState struct {
+	state  string
+	since  int64
+	reason string
+}
+
+// minTraceGoMinor is the minor version of the first go toolchain whose trace
+// tool prints the parsed events with -d=parsed, go1.23.
+const minTraceGoMinor = 23
+
+// goVersionRegExp matches the minor version of a go version, e.g. go1.23.4
+// or go1.24rc1.
+var goVersionRegExp = regexp.MustCompile(`^go1\.(\d+)`)
+
+// checkTraceToolchain fails if the go toolchain of the given version, as
+// printed by go env GOVERSION, cannot dump the parsed events of a trace.
+// Development versions are assumed to support it.
+func checkTraceToolchain(goVersion string) error {
+	if strings.HasPrefix(goVersion, "devel ") {
+		return nil
+	}
+	matches := goVersionRegExp.FindStringSubmatch(goVersion)
+	if matches == nil {
+		return fmt.Errorf("unable to parse the go version %q", goVersion)
+	}
+	if minor, _ := strconv.Atoi(matches[1]); minor < minTraceGoMinor {
+		return fmt.Errorf("summarizing execution traces requires go1.%d or later, as it relies on go tool trace -d=parsed, got %s", minTraceGoMinor, goVersion)
+	}
+	return nil
+}
+
+// parseTraceDump summarizes the events printed by go tool trace -d=parsed,
+// whose format is the one of go1.23 and later: checkTraceToolchain must
+// accept the toolchain first. The go tool decodes every trace format version
+// it supports into these events.
+func parseTraceDump(r io.Reader) (summary TraceSummary, err error) {
+	var first, last int64 = -1, 0
+	goroutines := map[string]*goroutineState{}
+	ranges := map[string]int64{}
+	live, created := 0, 0
+	var gcPauses, schedLatency, syscalls, networkWaits []int64
+	summary.WaitsNs = map[string]int64{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		matches := traceEventRegExp.FindStringSubmatch(scanner.Text())
+		if matches == nil {
+			continue
+		}
+		kind, rest := matches[1], matches[3]
+		ts, err := strconv.ParseInt(matches[2], 10, 64)
+		if err != nil {
+			return summary, err
+		}
+		if first < 0 {
+			first = ts
+		}
+		last = ts
+		switch kind {
+		case "StateTransition":
+			t := traceTransitionRegExp.FindStringSubmatch(rest)
+			if t == nil {
+				// Proc transitions.
+				continue
+			}
+			id, from, to, reason := t[1], t[2], t[3], t[4]
+			g, ok := goroutines[id]
+			if !ok {
+				g = &goroutineState{state: "NotExist"}
+				goroutines[id] = g
+			}
+			switch {
+			case from == "NotExist" && to != "NotExist":
+				created++
+				live++
+			case from == "Undetermined" && g.state == "NotExist" && to != "NotExist":
+				live++
+			case from != "NotExist" && to == "NotExist" && g.state != "NotExist":
+				live--
+			}
+			if live > summary.MaxGoroutines {
+				summary.MaxGoroutines = live
+			}
+			if from != "Undetermined" {
+				d := ts - g.since
+				switch {
+				case from == "Runnable" && to == "Running":
+					schedLatency = append(schedLatency, d)
+				case from == "Syscall":
+					syscalls = append(syscalls, d)
+				case from == "Waiting":
+					summary.WaitsNs[g.reason] += d
+					if g.reason == "network" {
+						networkWaits = append(networkWaits, d)
+					}
+				}
+			}
+			g.state, g.since, g.reason = to, ts, reason
+		case "RangeBegin", "RangeEnd":
+			rg := traceRangeRegExp.FindStringSubmatch(rest)
+			if rg == nil {
+				continue
+			}
+			name, key := rg[1], rg[1]+"@"+rg[2]
+			if kind == "RangeBegin" {
+				ranges[key] = ts
+				if name == "GC concurrent mark phase" {
+					summary.GCCycles++
+				}
+				continue
+			}
+			begin, ok := ranges[key]
+			if !ok {
+				continue
+			}
+			delete(ranges, key)
+			if strings.HasPrefix(name, "stop-the-world (GC") {
+				gcPauses = append(gcPauses, ts-begin)
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	if first >= 0 {
+		summary.DurationNs = last - first
+	}
+	summary.GoroutinesCreated = created
+	summary.GCPauses = newDurationStats(gcPauses)
+	summary.SchedLatency = newDurationStats(schedLatency)
+	summary.Syscalls = newDurationStats(syscalls)
+	summary.NetworkWaits = newDurationStats(networkWaits)
+	return
+}
+
+// traceFileName returns the name of the file the execution trace of the given
+// run of the benchmark is written to. Traces cannot be merged, so every run
+// keeps its own file when there are several.
+func traceFileName(b Benchmark, run int, runs int) string {
+	name := fmt.Sprintf("trace-%s.out", b.FileName())
+	if runs > 1 {
+		name = fmt.Sprintf("%s.%d", name, run)
+	}
+	return name
+}
+
+// summarizeTrace derives the runtime summary of an execution trace written
+// by go test -trace. go tool trace is stopped once ctx is done.
+func summarizeTrace(ctx context.Context, goPath string, traceFile string) (summary TraceSummary, err error) {
+	c := command{Name: goPath, Args: []string{"tool", "trace", "-d=parsed", traceFile}, Env: commandEnv()}
+	out, err := c.run(ctx)
+	if err != nil {
+		return summary, commandError(c, err, out)
+	}
+	return parseTraceDump(bytes.NewReader(out))
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const traceDumpFixture = `M=1 P=0 G=-1 Sync Time=1000 N=1
+M=1 P=0 G=1 StateTransition Time=1000 GoID=1 Undetermined->Running Reason=""
+M=1 P=0 G=1 StateTransition Time=1100 GoID=2 NotExist->Runnable Reason=""
+Stack=
+	main.main @ 0x4a1b2c
+		/tmp/main.go:10
+M=1 P=0 G=1 StateTransition Time=1200 GoID=1 Running->Waiting Reason="chan receive"
+M=1 P=0 G=-1 StateTransition Time=1250 GoID=2 Runnable->Running Reason=""
+M=1 P=0 G=2 RangeBegin Time=1300 Name="stop-the-world (GC sweep termination)" Scope=Goroutine(2)
+M=1 P=0 G=2 RangeEnd Time=1340 Name="stop-the-world (GC sweep termination)" Scope=Goroutine(2)
+M=1 P=0 G=2 RangeBegin Time=1350 Name="GC concurrent mark phase" Scope=None
+M=1 P=0 G=2 StateTransition Time=1400 GoID=2 Running->Syscall Reason=""
+M=1 P=-1 G=2 StateTransition Time=1600 GoID=2 Syscall->Running Reason=""
+M=1 P=0 G=2 StateTransition Time=1700 GoID=1 Waiting->Runnable Reason=""
+M=1 P=0 G=2 StateTransition Time=1800 GoID=2 Running->Waiting Reason="network"
+M=1 P=0 G=-1 StateTransition Time=1850 GoID=1 Runnable->Running Reason=""
+M=1 P=0 G=2 RangeEnd Time=1900 Name="GC concurrent mark phase" Scope=None
+M=1 P=0 G=1 RangeBegin Time=1950 Name="stop-the-world (GC mark termination)" Scope=Goroutine(1)
+M=1 P=0 G=1 RangeEnd Time=1960 Name="stop-the-world (GC mark termination)" Scope=Goroutine(1)
+M=1 P=0 G=1 StateTransition Time=2000 GoID=2 Waiting->Runnable Reason=""
+M=1 P=0 G=1 StateTransition Time=2100 GoID=1 Running->NotExist Reason=""
+`
+
+func Test_parseTraceDump(t *testing.T) {
+	got, err := parseTraceDump(strings.NewReader(traceDumpFixture))
+	if err != nil {
+		t.Fatalf("parseTraceDump() error = %v", err)
+	}
+	want := TraceSummary{
+		DurationNs:        1100,
+		GCCycles:          1,
+		GCPauses:          DurationStats{Count: 2, TotalNs: 50, P50Ns: 40, P90Ns: 40, P99Ns: 40, MaxNs: 40},
+		GoroutinesCreated: 1,
+		MaxGoroutines:     2,
+		SchedLatency:      DurationStats{Count: 2, TotalNs: 300, P50Ns: 150, P90Ns: 150, P99Ns: 150, MaxNs: 150},
+		Syscalls:          DurationStats{Count: 1, TotalNs: 200, P50Ns: 200, P90Ns: 200, P99Ns: 200, MaxNs: 200},
+		NetworkWaits:      DurationStats{Count: 1, TotalNs: 200, P50Ns: 200, P90Ns: 200, P99Ns: 200, MaxNs: 200},
+		WaitsNs:           map[string]int64{"chan receive": 500, "network": 200},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTraceDump() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_newDurationStats(t *testing.T) {
+	tests := []struct {
+		name      string
+		durations []int64
+		want      DurationStats
+	}{
+		{"empty", nil, DurationStats{}},
+		{"single", []int64{7}, DurationStats{Count: 1, TotalNs: 7, P50Ns: 7, P90Ns: 7, P99Ns: 7, MaxNs: 7}},
+		{"unsorted", []int64{5, 1, 4, 2, 3}, DurationStats{Count: 5, TotalNs: 15, P50Ns: 3, P90Ns: 5, P99Ns: 5, MaxNs: 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newDurationStats(tt.durations); got != tt.want {
+				t.Errorf("newDurationStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkTraceToolchain(t *testing.T) {
+	tests := []struct {
+		goVersion string
+		wantErr   bool
+	}{
+		{"go1.23.0", false},
+		{"go1.24rc1", false},
+		{"go1.27.1", false},
+		{"devel go1.28-abcdef Mon Jan 1 00:00:00 2026 +0000", false},
+		{"go1.22.5", true},
+		{"go1.21", true},
+		{"unknown", true},
+	}
+	for _, tt := range tests {
+		if err := checkTraceToolchain(tt.goVersion); (err != nil) != tt.wantErr {
+			t.Errorf("checkTraceToolchain(%q) error = %v, wantErr %v", tt.goVersion, err, tt.wantErr)
+		}
+	}
+}